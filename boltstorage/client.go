@@ -0,0 +1,101 @@
+package boltstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+)
+
+var defaultBucket = []byte("default")
+
+var _ storage.Client = &client{}
+
+type client struct {
+	db      *bolt.DB
+	once    sync.Once
+	onClose func()
+}
+
+// Get implements storage.Client. It returns (nil, nil) when the key is missing.
+func (c *client) Get(ctx context.Context, key string) ([]byte, error) {
+	op := storage.GetOperation(key)
+	if err := c.Batch(ctx, op); err != nil {
+		return nil, err
+	}
+	return op.Value, nil
+}
+
+// Set implements storage.Client.
+func (c *client) Set(ctx context.Context, key string, value []byte) error {
+	return c.Batch(ctx, storage.SetOperation(key, value))
+}
+
+// Delete implements storage.Client.
+func (c *client) Delete(ctx context.Context, key string) error {
+	return c.Batch(ctx, storage.DeleteOperation(key))
+}
+
+// Batch implements storage.Client. All operations run in one transaction, so
+// either every write lands or none of them do. Get results are put in place.
+func (c *client) Batch(ctx context.Context, ops ...*storage.Operation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	writable := false
+	for _, op := range ops {
+		if op.Type != storage.Get {
+			writable = true
+			break
+		}
+	}
+
+	fn := func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(defaultBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket %q not found", defaultBucket)
+		}
+		for _, op := range ops {
+			switch op.Type {
+			case storage.Get:
+				if v := bucket.Get([]byte(op.Key)); v != nil {
+					// Values are only valid for the life of the transaction.
+					op.Value = append([]byte(nil), v...)
+				} else {
+					op.Value = nil
+				}
+			case storage.Set:
+				if err := bucket.Put([]byte(op.Key), op.Value); err != nil {
+					return err
+				}
+			case storage.Delete:
+				if err := bucket.Delete([]byte(op.Key)); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unsupported operation type %d", op.Type)
+			}
+		}
+		return nil
+	}
+
+	if writable {
+		return c.db.Update(fn)
+	}
+	return c.db.View(fn)
+}
+
+// Close implements storage.Client.
+func (c *client) Close(ctx context.Context) error {
+	var err error
+	c.once.Do(func() {
+		err = c.db.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
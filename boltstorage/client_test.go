@@ -0,0 +1,175 @@
+package boltstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+	"go.uber.org/zap"
+)
+
+// startExtension starts an extension keeping its files in a temp directory.
+func startExtension(t *testing.T, cfg *Config) *ext {
+	t.Helper()
+	if cfg == nil {
+		cfg = createDefaultConfig().(*Config)
+	}
+	cfg.Directory = filepath.Join(t.TempDir(), "data")
+	e := newExtension(zap.NewNop(), cfg)
+	if err := e.Start(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := e.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return e
+}
+
+func getClient(t *testing.T, e *ext, id component.ID, storageName string) storage.Client {
+	t.Helper()
+	c, err := e.GetClient(context.Background(), component.KindProcessor, id, storageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func mustGet(t *testing.T, c storage.Client, key string) []byte {
+	t.Helper()
+	v, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestClientGetSetDelete(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, startExtension(t, nil), component.MustNewID("simple"), "")
+
+	if v := mustGet(t, c, "missing"); v != nil {
+		t.Fatalf("Get of a missing key = %q, want nil", v)
+	}
+	if err := c.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "key"); string(v) != "value" {
+		t.Fatalf("Get = %q, want value", v)
+	}
+	if err := c.Set(ctx, "key", []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "key"); string(v) != "updated" {
+		t.Fatalf("Get after overwrite = %q, want updated", v)
+	}
+	if err := c.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "key"); v != nil {
+		t.Fatalf("Get after Delete = %q, want nil", v)
+	}
+	if err := c.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, startExtension(t, nil), component.MustNewID("simple"), "")
+	if err := c.Set(ctx, "old", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	getOld, getNew := storage.GetOperation("old"), storage.GetOperation("new")
+	err := c.Batch(ctx,
+		storage.SetOperation("new", []byte("2")),
+		getOld,
+		storage.DeleteOperation("old"),
+		getNew,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Operations run in order, so gets see the writes before them.
+	if string(getOld.Value) != "1" || string(getNew.Value) != "2" {
+		t.Fatalf("Batch gets = %q, %q, want 1, 2", getOld.Value, getNew.Value)
+	}
+	if v := mustGet(t, c, "old"); v != nil {
+		t.Fatalf("Get of a key deleted in a batch = %q, want nil", v)
+	}
+
+	// A get of a missing key clears a value left in the operation.
+	stale := storage.GetOperation("old")
+	stale.Value = []byte("stale")
+	if err := c.Batch(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if stale.Value != nil {
+		t.Fatalf("Batch get of a missing key = %q, want nil", stale.Value)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.Batch(canceled, storage.SetOperation("new", []byte("3"))); err == nil {
+		t.Fatal("Batch with a canceled context succeeded")
+	}
+	if v := mustGet(t, c, "new"); string(v) != "2" {
+		t.Fatalf("Get after a canceled batch = %q, want 2", v)
+	}
+}
+
+func TestClientIsolation(t *testing.T) {
+	ctx := context.Background()
+	e := startExtension(t, nil)
+	clients := []storage.Client{
+		getClient(t, e, component.MustNewID("simple"), ""),
+		getClient(t, e, component.MustNewIDWithName("simple", "other"), ""),
+		getClient(t, e, component.MustNewID("simple"), "secondary"),
+	}
+	for i, c := range clients {
+		if err := c.Set(ctx, "key", []byte{byte('a' + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, c := range clients {
+		if v := mustGet(t, c, "key"); string(v) != string(rune('a'+i)) {
+			t.Fatalf("client %d sees %q, want %q", i, v, string(rune('a'+i)))
+		}
+	}
+
+	if _, err := e.GetClient(ctx, component.KindProcessor, component.MustNewID("simple"), ""); err == nil {
+		t.Fatal("a second client of the same component was opened")
+	}
+	if _, err := e.GetClient(ctx, component.KindExporter, component.MustNewID("simple"), ""); err != nil {
+		t.Fatalf("a component of another kind: %v", err)
+	}
+}
+
+func TestClientReopen(t *testing.T) {
+	ctx := context.Background()
+	cfg := createDefaultConfig().(*Config)
+	cfg.Compaction.OnStart = true
+	e := startExtension(t, cfg)
+	id := component.MustNewID("simple")
+
+	c := getClient(t, e, id, "")
+	if err := c.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	// The database is compacted before it is opened again.
+	c = getClient(t, e, id, "")
+	if v := mustGet(t, c, "key"); string(v) != "value" {
+		t.Fatalf("Get after reopening = %q, want value", v)
+	}
+}
@@ -0,0 +1,237 @@
+package boltstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+	"go.uber.org/zap"
+)
+
+var _ storage.Extension = &ext{}
+
+type ext struct {
+	logger *zap.Logger
+	cfg    *Config
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+func newExtension(logger *zap.Logger, cfg *Config) *ext {
+	return &ext{
+		logger:  logger,
+		cfg:     cfg,
+		clients: make(map[string]*client),
+	}
+}
+
+// Start implements extension.Extension.
+func (e *ext) Start(ctx context.Context, host component.Host) error {
+	if err := os.MkdirAll(e.cfg.Directory, 0o750); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	if e.cfg.Compaction.Directory != "" {
+		if err := os.MkdirAll(e.cfg.Compaction.Directory, 0o750); err != nil {
+			return fmt.Errorf("failed to create compaction directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// Shutdown implements extension.Extension.
+func (e *ext) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	clients := make([]*client, 0, len(e.clients))
+	for _, c := range e.clients {
+		clients = append(clients, c)
+	}
+	e.mu.Unlock()
+
+	var errs []error
+	for _, c := range clients {
+		errs = append(errs, c.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// GetClient implements storage.Extension. Each kind, component and storage
+// name combination is kept in its own database file so components never see
+// each other's keys.
+func (e *ext) GetClient(ctx context.Context, kind component.Kind, id component.ID, storageName string) (storage.Client, error) {
+	name := namespace(kind, id, storageName)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.clients[name]; ok {
+		return nil, fmt.Errorf("storage client %q is already open", name)
+	}
+
+	path := filepath.Join(e.cfg.Directory, name)
+	if e.cfg.Compaction.OnStart {
+		if err := e.compact(path); err != nil {
+			e.logger.Warn("Failed to compact database, using it as is", zap.String("path", path), zap.Error(err))
+		}
+	}
+
+	db, err := e.open(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %q: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(defaultBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	c := &client{
+		db: db,
+		onClose: func() {
+			e.mu.Lock()
+			delete(e.clients, name)
+			e.mu.Unlock()
+		},
+	}
+	e.clients[name] = c
+	return c, nil
+}
+
+func (e *ext) open(path string, readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(path, 0o600, &bolt.Options{
+		Timeout:        e.cfg.Timeout,
+		NoSync:         !e.cfg.FSync,
+		NoFreelistSync: true,
+		FreelistType:   bolt.FreelistMapType,
+		ReadOnly:       readOnly,
+	})
+}
+
+// compact rewrites the database at path into a fresh file and swaps it in.
+// A missing file is not an error, there is simply nothing to compact yet.
+func (e *ext) compact(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	dir := e.cfg.Compaction.Directory
+	if dir == "" {
+		dir = e.cfg.Directory
+	}
+	tmpPath, err := tempPath(dir, filepath.Base(path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	src, err := e.open(path, true)
+	if err != nil {
+		return err
+	}
+	// Always synced, the file replaces the database.
+	dst, err := bolt.Open(tmpPath, 0o600, &bolt.Options{Timeout: e.cfg.Timeout})
+	if err != nil {
+		_ = src.Close()
+		return err
+	}
+	err = bolt.Compact(dst, src, e.cfg.Compaction.MaxTransactionSize)
+	err = errors.Join(err, src.Close(), dst.Close())
+	if err != nil {
+		return err
+	}
+
+	// A rename only swaps files within one file system, and the compaction
+	// directory can be another one, such as a tmpfs /tmp.
+	if filepath.Clean(dir) != filepath.Dir(path) {
+		copyPath, err := tempPath(filepath.Dir(path), filepath.Base(path)+".compact-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(copyPath)
+		if err := copyFile(copyPath, tmpPath); err != nil {
+			return err
+		}
+		tmpPath = copyPath
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// tempPath creates an empty temporary file and returns its path.
+func tempPath(dir, pattern string) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// copyFile copies src over dst and syncs dst to disk.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return errors.Join(out.Sync(), out.Close())
+}
+
+// syncDir makes a rename within dir durable. Windows can't sync directories.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
+
+// namespace builds a file name that is unique per component and storage name.
+func namespace(kind component.Kind, id component.ID, storageName string) string {
+	parts := []string{strings.ToLower(kind.String()), id.Type().String()}
+	if id.Name() != "" {
+		parts = append(parts, id.Name())
+	}
+	if storageName != "" {
+		parts = append(parts, storageName)
+	}
+	return sanitize(strings.Join(parts, "_"))
+}
+
+// sanitize replaces anything that is not safe in a file name.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		default:
+			return '~'
+		}
+	}, name)
+}
@@ -0,0 +1,82 @@
+package boltstorage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension"
+)
+
+var (
+	Type = component.MustNewType("bolt_storage")
+)
+
+// Config represents the configuration for the bolt storage extension.
+type Config struct {
+	// Directory is where the database files are kept. Every component that
+	// asks for a client gets its own file in this directory.
+	Directory string `mapstructure:"directory"`
+
+	// Timeout is how long to wait for the file lock when opening a database.
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// FSync forces an fsync after every write transaction. Turning it off
+	// is faster but can lose the latest writes on a crash.
+	FSync bool `mapstructure:"fsync"`
+
+	Compaction CompactionConfig `mapstructure:"compaction"`
+}
+
+// CompactionConfig controls rewriting database files to reclaim free pages.
+type CompactionConfig struct {
+	// OnStart compacts each database file before the client is handed out.
+	OnStart bool `mapstructure:"on_start"`
+
+	// Directory holds the temporary file used during compaction. Defaults to
+	// the storage directory. On another file system, the compacted file is
+	// copied next to the database before it is swapped in.
+	Directory string `mapstructure:"directory"`
+
+	// MaxTransactionSize is the number of bytes copied per transaction while
+	// compacting. Zero copies everything in a single transaction.
+	MaxTransactionSize int64 `mapstructure:"max_transaction_size"`
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if c.Directory == "" {
+		return errors.New("directory must be set")
+	}
+	if c.Compaction.MaxTransactionSize < 0 {
+		return errors.New("compaction.max_transaction_size must not be negative")
+	}
+	return nil
+}
+
+// NewFactory creates a factory for the bolt storage extension.
+func NewFactory() extension.Factory {
+	return extension.NewFactory(
+		Type,
+		createDefaultConfig,
+		createExtension,
+		component.StabilityLevelDevelopment,
+	)
+}
+
+func createDefaultConfig() component.Config {
+	return &Config{
+		Directory: "./data",
+		Timeout:   time.Second,
+		FSync:     true,
+		Compaction: CompactionConfig{
+			OnStart:            false,
+			MaxTransactionSize: 65536,
+		},
+	}
+}
+
+func createExtension(_ context.Context, set extension.Settings, cfg component.Config) (extension.Extension, error) {
+	return newExtension(set.Logger, cfg.(*Config)), nil
+}
@@ -0,0 +1,25 @@
+module github.com/wylswz/boltstorage
+
+go 1.24.0
+
+require (
+	go.etcd.io/bbolt v1.4.3
+	go.opentelemetry.io/collector/component v1.46.0
+	go.opentelemetry.io/collector/extension v1.46.0
+	go.opentelemetry.io/collector/extension/xextension v0.140.0
+	go.uber.org/zap v1.27.0
+)
+
+require (
+	github.com/hashicorp/go-version v1.7.0 // indirect
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.3-0.20250322232337-35a7c28c31ee // indirect
+	go.opentelemetry.io/collector/featuregate v1.46.0 // indirect
+	go.opentelemetry.io/collector/pdata v1.46.0 // indirect
+	go.opentelemetry.io/otel v1.38.0 // indirect
+	go.opentelemetry.io/otel/metric v1.38.0 // indirect
+	go.opentelemetry.io/otel/trace v1.38.0 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+)
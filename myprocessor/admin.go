@@ -0,0 +1,264 @@
+package simpleprocessor
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// adminRequest selects the series an admin operation applies to. An empty
+// tenant means every tenant, an empty rule every rule, empty match every
+// series of the rule.
+type adminRequest struct {
+	Tenant     string            `json:"tenant"`
+	Rule       string            `json:"rule"`
+	Match      map[string]string `json:"match"`
+	Attributes map[string]string `json:"attributes"`
+	Resource   map[string]string `json:"resource"`
+	Value      *int64            `json:"value"`
+}
+
+type adminResponse struct {
+	Affected   int    `json:"affected"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// registerAdmin adds the admin operations to mux. They are only served when
+// an admin token is configured.
+func (p *simpleProcessor) registerAdmin(mux *http.ServeMux) {
+	mux.Handle("POST /admin/reset", p.requireToken(p.handleAdmin(p.adminReset)))
+	mux.Handle("POST /admin/delete", p.requireToken(p.handleAdmin(p.adminDelete)))
+	mux.Handle("POST /admin/set", p.requireToken(p.handleAdmin(p.adminSet)))
+}
+
+// requireToken rejects requests that don't carry the admin bearer token.
+func (p *simpleProcessor) requireToken(next http.Handler) http.Handler {
+	want := []byte(p.cfg.Introspection.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), want) != 1 {
+			writeJSON(w, http.StatusUnauthorized, adminResponse{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAdmin decodes the request, applies op under the state lock so it is
+// atomic with respect to ConsumeMetrics and flushes, and checkpoints the
+// result right away.
+func (p *simpleProcessor) handleAdmin(op func(req *adminRequest, now time.Time) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req adminRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		tenant, err := p.scopeTenant(r.Context(), req.Tenant)
+		if err != nil {
+			writeJSON(w, http.StatusForbidden, adminResponse{Error: err.Error()})
+			return
+		}
+		req.Tenant = tenant
+
+		ctx := r.Context()
+		p.lock(ctx, opAdmin)
+		defer p.mu.Unlock()
+
+		affected, err := op(&req, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, adminResponse{Error: err.Error()})
+			return
+		}
+		p.logger.Info("Applied admin operation", zap.String("path", r.URL.Path),
+			zap.String("tenant", req.Tenant), zap.String("rule", req.Rule), zap.Any("match", req.Match), zap.Int("affected", affected))
+
+		resp := adminResponse{Affected: affected, Checkpoint: "ok"}
+		if p.primary == nil {
+			resp.Checkpoint = "skipped"
+		}
+		if err := p.saveStateLocked(ctx); err != nil {
+			resp.Checkpoint = "failed"
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// selectRules returns the state of the rules a request applies to, of every
+// tenant it applies to.
+func (p *simpleProcessor) selectRules(req *adminRequest) ([]selectedRule, error) {
+	tenants := p.tenants
+	if req.Tenant != "" {
+		t, ok := p.tenants[req.Tenant]
+		if !ok {
+			return nil, fmt.Errorf("tenant %q not found", req.Tenant)
+		}
+		tenants = map[string]*tenantState{req.Tenant: t}
+	}
+	var selected []selectedRule
+	for _, t := range tenants {
+		for name, state := range t.rules {
+			if req.Rule == "" || name == req.Rule {
+				selected = append(selected, selectedRule{tenant: t, name: name, state: state})
+			}
+		}
+	}
+	if req.Rule != "" && len(selected) == 0 {
+		return nil, fmt.Errorf("rule %q not found", req.Rule)
+	}
+	return selected, nil
+}
+
+// selectedRule is the state of a rule selected by an admin request.
+type selectedRule struct {
+	tenant *tenantState
+	name   string
+	state  *ruleState
+}
+
+// adminReset zeroes the selected series and starts them over at now.
+func (p *simpleProcessor) adminReset(req *adminRequest, now time.Time) (int, error) {
+	rules, err := p.selectRules(req)
+	if err != nil {
+		return 0, err
+	}
+	affected := 0
+	for _, sel := range rules {
+		r := p.rule(sel.name)
+		for _, s := range sel.state.Series {
+			if s.matches(req.Match) {
+				r.reset(s, now)
+				affected++
+			}
+		}
+	}
+	return affected, nil
+}
+
+// adminDelete removes the selected series. Alerts stop tracking them without
+// a resolved record, and absent alerts don't fire for them.
+func (p *simpleProcessor) adminDelete(req *adminRequest, _ time.Time) (int, error) {
+	rules, err := p.selectRules(req)
+	if err != nil {
+		return 0, err
+	}
+	affected := 0
+	for _, sel := range rules {
+		for key, s := range sel.state.Series {
+			if !s.matches(req.Match) {
+				continue
+			}
+			delete(sel.state.Series, key)
+			for _, a := range p.alerts {
+				if a.Rule == sel.name {
+					a.forgetSeries(sel.tenant, key)
+				}
+			}
+			affected++
+		}
+	}
+	return affected, nil
+}
+
+// adminSet sets the value of the series with exactly the given attributes and
+// resource, creating it if needed. The series starts over at now since its
+// value no longer continues from the previous one. The tenant and the state of
+// a configured rule are created too, so a series can be seeded before any
+// data point arrives. The value goes through the validation of the rule.
+func (p *simpleProcessor) adminSet(req *adminRequest, now time.Time) (int, error) {
+	if req.Rule == "" || req.Value == nil {
+		return 0, fmt.Errorf("set needs rule and value")
+	}
+	tenancy := p.cfg.Tenancy
+	if tenancy != nil && req.Tenant == "" {
+		return 0, fmt.Errorf("set needs a tenant when tenancy is on")
+	}
+	_, configured := p.configuredRule(req.Rule)
+	t, known := p.tenants[req.Tenant]
+	if !known && !configured {
+		return 0, fmt.Errorf("tenant %q not found", req.Tenant)
+	}
+	if known && t.rules[req.Rule] == nil && !configured {
+		return 0, fmt.Errorf("rule %q not found", req.Rule)
+	}
+	r := p.rule(req.Rule)
+	if r.aggregation() != AggregationSum {
+		return 0, fmt.Errorf("set is not supported for %s rules", r.aggregation())
+	}
+	if r.Window.enabled() {
+		return 0, fmt.Errorf("set is not supported for windowed rules")
+	}
+	// Only series data points could have made: the group_by attributes,
+	// and resource attributes the rule keeps.
+	if group, ok := r.groupAttributes(attributeMap(req.Attributes)); !ok || len(group) != len(req.Attributes) {
+		return 0, fmt.Errorf("attributes must be the group_by attributes of rule %q, %q", req.Rule, r.GroupBy)
+	}
+	if len(r.resourceAttributes(attributeMap(req.Resource))) != len(req.Resource) {
+		return 0, fmt.Errorf("resource must only have the resource_attributes of rule %q, %q", req.Rule, r.ResourceAttributes)
+	}
+	dp := pmetric.NewNumberDataPoint()
+	dp.SetIntValue(*req.Value)
+	if reason := r.validate(dp); reason != "" {
+		return 0, fmt.Errorf("value %d rejected by rule %q: %s", *req.Value, req.Rule, reason)
+	}
+	// A new tenant is admitted like one that sent a data point, but never
+	// folded into the overflow tenant.
+	if !known && tenancy != nil {
+		if admitted, ok := p.admitTenantLocked(req.Tenant); !ok || admitted != req.Tenant {
+			return 0, fmt.Errorf("tenant %q is over max_tenants", req.Tenant)
+		}
+	}
+
+	t = p.tenantLocked(req.Tenant)
+	state := t.ruleStateLocked(req.Rule)
+	key := resourceSeriesKey(req.Resource, req.Attributes)
+	s, ok := state.Series[key]
+	if !ok {
+		if tenancy != nil && tenancy.MaxSeries > 0 && t.seriesCount() >= tenancy.MaxSeries {
+			return 0, fmt.Errorf("tenant %q is at max_series", req.Tenant)
+		}
+		s = &series{Attributes: req.Attributes, Resource: req.Resource, LastSeen: now}
+		state.Series[key] = s
+	}
+	s.Value, s.Remainder = *req.Value, 0
+	s.StartTime = now
+	return 1, nil
+}
+
+// attributeMap converts attributes to a pcommon.Map.
+func attributeMap(attrs map[string]string) pcommon.Map {
+	m := pcommon.NewMap()
+	putAttributes(m, attrs)
+	return m
+}
+
+// configuredRule returns the configured rule called name, or false if there
+// is none.
+func (p *simpleProcessor) configuredRule(name string) (*rule, bool) {
+	for _, r := range p.rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// rule returns the configured rule called name. State of rules that are no
+// longer configured is treated as a sum.
+func (p *simpleProcessor) rule(name string) *rule {
+	if r, ok := p.configuredRule(name); ok {
+		return r
+	}
+	return &rule{RuleConfig: RuleConfig{Name: name}}
+}
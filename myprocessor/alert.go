@@ -0,0 +1,417 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+// Conditions an alert can check.
+const (
+	// AlertConditionThreshold fires while the value or rate of a series
+	// crosses the threshold.
+	AlertConditionThreshold = "threshold"
+	// AlertConditionAbsent fires while a series hasn't been updated for the
+	// over duration.
+	AlertConditionAbsent = "absent"
+)
+
+// Values a threshold alert compares.
+const (
+	// AlertValueValue is the value of the series as flushed: the sum, the
+	// distinct count estimate, the number of observations of a quantile
+	// rule or the total of a top_k group.
+	AlertValueValue = "value"
+	// AlertValueRate is the per second increase of the value over the over
+	// duration.
+	AlertValueRate = "rate"
+)
+
+var alertOps = map[string]func(v, threshold float64) bool{
+	">":  func(v, threshold float64) bool { return v > threshold },
+	">=": func(v, threshold float64) bool { return v >= threshold },
+	"<":  func(v, threshold float64) bool { return v < threshold },
+	"<=": func(v, threshold float64) bool { return v <= threshold },
+}
+
+// Alert states, as carried by the alert.state attribute of alert log records.
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// AlertConfig defines an alert on the series of a rule. Alerts are evaluated
+// on every flush and emit a log record whenever a series starts or stops
+// firing.
+type AlertConfig struct {
+	// Name of the alert.
+	Name string `mapstructure:"name"`
+
+	// Rule is the rule whose series are checked.
+	Rule string `mapstructure:"rule"`
+
+	// Match restricts the alert to series with these attribute values, e.g.
+	// work.type: failed.
+	Match map[string]string `mapstructure:"match"`
+
+	// Condition is threshold (default) or absent.
+	Condition string `mapstructure:"condition"`
+
+	// Value is value (default) or rate, for threshold alerts.
+	Value string `mapstructure:"value"`
+
+	// Op compares the value with the threshold: >, >=, < or <=. Defaults to >.
+	Op string `mapstructure:"op"`
+
+	// Threshold is what the value is compared with.
+	Threshold float64 `mapstructure:"threshold"`
+
+	// Over is the duration a rate is taken over, or a series must be missing
+	// for before an absent alert fires.
+	Over time.Duration `mapstructure:"over"`
+
+	// Severity is the severity text of firing records. Defaults to WARN.
+	// Resolved records are INFO.
+	Severity string `mapstructure:"severity"`
+}
+
+func (c *AlertConfig) condition() string {
+	if c.Condition == "" {
+		return AlertConditionThreshold
+	}
+	return c.Condition
+}
+
+func (c *AlertConfig) value() string {
+	if c.Value == "" {
+		return AlertValueValue
+	}
+	return c.Value
+}
+
+func (c *AlertConfig) op() string {
+	if c.Op == "" {
+		return ">"
+	}
+	return c.Op
+}
+
+func (c *AlertConfig) severity() string {
+	if c.Severity == "" {
+		return "WARN"
+	}
+	return c.Severity
+}
+
+// Validate checks the alert on its own, validateAlerts checks it against the
+// rule it refers to.
+func (c *AlertConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("name must be set")
+	}
+	if c.Rule == "" {
+		return errors.New("rule must be set")
+	}
+	switch c.condition() {
+	case AlertConditionThreshold:
+		switch c.value() {
+		case AlertValueValue:
+		case AlertValueRate:
+			if c.Over <= 0 {
+				return errors.New("over must be positive for rates")
+			}
+		default:
+			return errors.New("value must be value or rate")
+		}
+		if _, ok := alertOps[c.op()]; !ok {
+			return errors.New("op must be >, >=, < or <=")
+		}
+	case AlertConditionAbsent:
+		if c.Over <= 0 {
+			return errors.New("over must be positive for absent alerts")
+		}
+		if c.Value != "" || c.Op != "" || c.Threshold != 0 {
+			return errors.New("value, op and threshold only apply to threshold alerts")
+		}
+	default:
+		return fmt.Errorf("unknown condition %q", c.Condition)
+	}
+	return nil
+}
+
+// validateAlerts checks the alerts and that they refer to rules that exist.
+// Threshold alerts need lifetime rules, the series of windowed rules have no
+// value outside their windows.
+func validateAlerts(alerts []AlertConfig, rules []RuleConfig) error {
+	if len(rules) == 0 {
+		rules = defaultRules()
+	}
+	names := make(map[string]struct{}, len(alerts))
+	for i := range alerts {
+		a := &alerts[i]
+		if err := a.Validate(); err != nil {
+			return fmt.Errorf("alerts[%d]: %w", i, err)
+		}
+		if _, ok := names[a.Name]; ok {
+			return fmt.Errorf("alerts[%d]: duplicate alert name %q", i, a.Name)
+		}
+		names[a.Name] = struct{}{}
+		j := slices.IndexFunc(rules, func(r RuleConfig) bool { return r.Name == a.Rule })
+		if j < 0 {
+			return fmt.Errorf("alerts[%d]: unknown rule %q", i, a.Rule)
+		}
+		if a.condition() == AlertConditionThreshold && rules[j].Window.enabled() {
+			return fmt.Errorf("alerts[%d]: threshold alerts need a rule without a window", i)
+		}
+	}
+	return nil
+}
+
+// alertState is the state of one alert of a tenant, keyed by the series key
+// of the rule.
+type alertState struct {
+	Series map[string]*alertSeries `json:"series"`
+}
+
+// alertSeries is the state of an alert for one series.
+type alertSeries struct {
+	Attributes map[string]string `json:"attributes"`
+	Resource   map[string]string `json:"resource,omitempty"`
+	Firing     bool              `json:"firing"`
+
+	// Since is when the series last started or stopped firing.
+	Since time.Time `json:"since,omitzero"`
+
+	// LastSeen is when the series was last updated, for absent alerts.
+	LastSeen time.Time `json:"last_seen,omitzero"`
+
+	// Samples are the values of the series at the flushes within the rate
+	// duration, oldest first.
+	Samples []alertSample `json:"samples,omitempty"`
+
+	// Pending is set until the record of the last transition is delivered,
+	// it is sent again on every flush until then. Value is what the alert
+	// compared at that transition.
+	Pending bool    `json:"pending,omitempty"`
+	Value   float64 `json:"value,omitempty"`
+}
+
+type alertSample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// alertEvent is a series starting or stopping to fire.
+type alertEvent struct {
+	tenant string
+	series *alertSeries
+	value  float64
+	retry  bool // the transition happened on an earlier flush
+}
+
+// alert is an AlertConfig ready to be evaluated.
+type alert struct {
+	AlertConfig
+
+	rule *rule
+}
+
+func newAlerts(cfgs []AlertConfig, rules []*rule) []*alert {
+	alerts := make([]*alert, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		// Validated with the config.
+		i := slices.IndexFunc(rules, func(r *rule) bool { return r.Name == cfg.Rule })
+		alerts = append(alerts, &alert{AlertConfig: cfg, rule: rules[i]})
+	}
+	return alerts
+}
+
+// evaluateLocked checks the alert against the series of a tenant's rule and
+// returns the series that started or stopped firing, along with those whose
+// earlier transition wasn't delivered yet.
+func (a *alert) evaluateLocked(tenant string, t *tenantState, now time.Time) []alertEvent {
+	as := t.alertStateLocked(a.Name)
+	fresh := make(map[*alertSeries]struct{})
+	transition := func(e *alertSeries, firing bool, value float64) {
+		if firing == e.Firing {
+			return
+		}
+		e.Firing, e.Since, e.Pending, e.Value = firing, now, true, value
+		fresh[e] = struct{}{}
+	}
+
+	seen := make(map[string]struct{})
+	if state, ok := t.rules[a.Rule]; ok {
+		for key, s := range state.Series {
+			if !s.matches(a.Match) {
+				continue
+			}
+			seen[key] = struct{}{}
+			e, ok := as.Series[key]
+			if !ok {
+				e = &alertSeries{Attributes: s.Attributes, Resource: s.Resource}
+				as.Series[key] = e
+			}
+			if s.LastSeen.After(e.LastSeen) {
+				e.LastSeen = s.LastSeen
+			}
+			if a.condition() == AlertConditionAbsent {
+				continue
+			}
+			if value, ok := a.threshold(e, a.rule.seriesValue(s), now); ok {
+				transition(e, alertOps[a.op()](value, a.Threshold), value)
+			}
+		}
+	}
+
+	// Series that go away are only forgotten once their resolved record was
+	// delivered.
+	gone := make(map[string]struct{})
+	if a.condition() != AlertConditionAbsent {
+		// Series that were reset or removed stop firing.
+		for key, e := range as.Series {
+			if _, ok := seen[key]; !ok {
+				transition(e, false, 0)
+				gone[key] = struct{}{}
+			}
+		}
+	} else {
+		// Until a series matches, the alert tracks one made of its
+		// matchers, missing from the first evaluation on.
+		placeholder := resourceSeriesKey(nil, a.Match)
+		if len(as.Series) == 0 {
+			as.Series[placeholder] = &alertSeries{Attributes: a.Match, LastSeen: now}
+		} else if e, ok := as.Series[placeholder]; ok && len(seen) > 0 {
+			if _, ok := seen[placeholder]; !ok {
+				transition(e, false, now.Sub(e.LastSeen).Seconds())
+				gone[placeholder] = struct{}{}
+			}
+		}
+		for _, key := range sortedKeys(as.Series) {
+			if _, ok := gone[key]; ok {
+				continue
+			}
+			e := as.Series[key]
+			missing := now.Sub(e.LastSeen)
+			transition(e, missing >= a.Over, missing.Seconds())
+		}
+	}
+
+	var events []alertEvent
+	for _, key := range sortedKeys(as.Series) {
+		e := as.Series[key]
+		if e.Pending {
+			_, ok := fresh[e]
+			events = append(events, alertEvent{tenant: tenant, series: e, value: e.Value, retry: !ok})
+			continue
+		}
+		if _, ok := gone[key]; ok {
+			delete(as.Series, key)
+		}
+	}
+	return events
+}
+
+// forgetSeries stops tracking a series of the rule of the alert, e.g. one
+// deleted through the admin API.
+func (a *alert) forgetSeries(t *tenantState, key string) {
+	if as, ok := t.alerts[a.Name]; ok {
+		delete(as.Series, key)
+	}
+}
+
+// threshold returns the value a threshold alert compares for a series, and
+// false if there isn't one yet. Rates keep a sample per flush.
+func (a *alert) threshold(e *alertSeries, value float64, now time.Time) (float64, bool) {
+	if a.value() != AlertValueRate {
+		return value, true
+	}
+	if n := len(e.Samples); n > 0 && value < e.Samples[n-1].Value {
+		// The series was reset, its old samples would give a negative rate.
+		e.Samples = e.Samples[:0]
+	}
+	e.Samples = append(e.Samples, alertSample{Time: now, Value: value})
+	i := 0
+	for i < len(e.Samples)-1 && now.Sub(e.Samples[i].Time) > a.Over {
+		i++
+	}
+	e.Samples = e.Samples[i:]
+	first := e.Samples[0]
+	elapsed := now.Sub(first.Time).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return (value - first.Value) / elapsed, true
+}
+
+// appendRecord adds the log record of an event to out.
+func (a *alert) appendRecord(out *output, ev alertEvent, now time.Time) {
+	record := out.logScope(ev.tenant, ev.series.Resource).LogRecords().AppendEmpty()
+	record.SetTimestamp(pcommon.NewTimestampFromTime(now))
+	record.SetObservedTimestamp(pcommon.NewTimestampFromTime(now))
+	state := alertResolved
+	if ev.series.Firing {
+		state = alertFiring
+		record.SetSeverityText(a.severity())
+		record.SetSeverityNumber(severityNumber(a.severity()))
+	} else {
+		record.SetSeverityText("INFO")
+		record.SetSeverityNumber(plog.SeverityNumberInfo)
+	}
+
+	attrs := record.Attributes()
+	putAttributes(attrs, ev.series.Attributes)
+	attrs.PutStr("alert.name", a.Name)
+	attrs.PutStr("alert.rule", a.Rule)
+	attrs.PutStr("alert.state", state)
+	if a.condition() == AlertConditionAbsent {
+		attrs.PutDouble("alert.missing_seconds", ev.value)
+		record.Body().SetStr(fmt.Sprintf("%s %s: %s missing for %s", a.Name, state, a.Rule, time.Duration(ev.value*float64(time.Second)).Round(time.Second)))
+		return
+	}
+	attrs.PutDouble("alert.value", ev.value)
+	attrs.PutDouble("alert.threshold", a.Threshold)
+	record.Body().SetStr(fmt.Sprintf("%s %s: %s %s %g %s %g", a.Name, state, a.Rule, a.value(), ev.value, a.op(), a.Threshold))
+}
+
+// severityNumber maps the usual severity texts to their numbers.
+func severityNumber(text string) plog.SeverityNumber {
+	switch text {
+	case "DEBUG", "debug":
+		return plog.SeverityNumberDebug
+	case "INFO", "info":
+		return plog.SeverityNumberInfo
+	case "ERROR", "error":
+		return plog.SeverityNumberError
+	case "FATAL", "fatal":
+		return plog.SeverityNumberFatal
+	default:
+		return plog.SeverityNumberWarn
+	}
+}
+
+// seriesValue returns the value of a lifetime series as flushed, which
+// alerts and derived metrics compare and combine.
+func (r *rule) seriesValue(s *series) float64 {
+	if r.aggregation() == AggregationDistinctCount && s.Distinct != nil {
+		return float64(s.Distinct.estimate())
+	}
+	return float64(s.Value)
+}
+
+// alertStateLocked returns the state of an alert, creating it on first use.
+func (t *tenantState) alertStateLocked(name string) *alertState {
+	if t.alerts == nil {
+		t.alerts = make(map[string]*alertState)
+	}
+	as, ok := t.alerts[name]
+	if !ok {
+		as = &alertState{Series: make(map[string]*alertSeries)}
+		t.alerts[name] = as
+	}
+	return as
+}
@@ -0,0 +1,171 @@
+package simpleprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// CheckpointKey is the key the processor keeps its state under in a storage
+// extension. It is also the name of the checkpoint file's main document.
+const CheckpointKey = checkpointKey
+
+// TenantCheckpointKey is the key the state of a tenant is kept under when
+// tenancy is on. The checkpoint file keeps it next to the main document, with
+// the key as a suffix.
+func TenantCheckpointKey(tenant string) string {
+	return tenantKey(tenant)
+}
+
+// The functions below give offline tools access to checkpoints without
+// starting a processor.
+
+// CheckpointVersion returns the format version of a stored checkpoint.
+func CheckpointVersion(data []byte) (int, error) {
+	var probe struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return 0, err
+	}
+	if probe.Version == nil {
+		return 1, nil
+	}
+	return *probe.Version, nil
+}
+
+// DumpCheckpoint decodes a checkpoint of any version and returns it as
+// indented JSON in the current format.
+func DumpCheckpoint(data []byte) ([]byte, error) {
+	cp, err := decodeCheckpoint(data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(cp, "", "  ")
+}
+
+// MigrateCheckpoint rewrites a checkpoint of any version in the current
+// format. Series migrated from a format without start times start now.
+func MigrateCheckpoint(data []byte) ([]byte, error) {
+	cp, err := decodeCheckpoint(data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return encodeCheckpoint(cp)
+}
+
+// ValidateCheckpoint decodes a checkpoint and returns the problems found in
+// it. A checkpoint that can't be decoded at all is returned as an error.
+func ValidateCheckpoint(data []byte) ([]string, error) {
+	cp, err := decodeCheckpoint(data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rules := cp.Rules
+
+	var problems []string
+	now := time.Now()
+	for _, name := range sortedKeys(rules) {
+		for _, key := range sortedKeys(rules[name].Series) {
+			s := rules[name].Series[key]
+			if want := resourceSeriesKey(s.Resource, s.Attributes); want != key {
+				problems = append(problems, fmt.Sprintf("rule %q series %q is keyed as %q", name, want, key))
+			}
+			if s.Value < 0 {
+				problems = append(problems, fmt.Sprintf("rule %q series %q has negative value %d", name, key, s.Value))
+			}
+			if !(math.Abs(s.Remainder) <= 0.5) {
+				problems = append(problems, fmt.Sprintf("rule %q series %q has remainder %g, more than half a count", name, key, s.Remainder))
+			}
+			if s.StartTime.After(now) {
+				problems = append(problems, fmt.Sprintf("rule %q series %q starts in the future", name, key))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// CheckpointDiff is one difference between two checkpoints. Old or New is
+// nil when the series only exists on one side.
+type CheckpointDiff struct {
+	Rule string
+	Key  string
+	Old  *int64
+	New  *int64
+}
+
+func (d CheckpointDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("+ %s{%s} %d", d.Rule, d.Key, *d.New)
+	case d.New == nil:
+		return fmt.Sprintf("- %s{%s} %d", d.Rule, d.Key, *d.Old)
+	default:
+		return fmt.Sprintf("~ %s{%s} %d -> %d", d.Rule, d.Key, *d.Old, *d.New)
+	}
+}
+
+// DiffCheckpoints compares the series values of two checkpoints of any version.
+func DiffCheckpoints(oldData, newData []byte) ([]CheckpointDiff, error) {
+	now := time.Now()
+	oldCP, err := decodeCheckpoint(oldData, now)
+	if err != nil {
+		return nil, fmt.Errorf("old checkpoint: %w", err)
+	}
+	newCP, err := decodeCheckpoint(newData, now)
+	if err != nil {
+		return nil, fmt.Errorf("new checkpoint: %w", err)
+	}
+	oldRules, newRules := oldCP.Rules, newCP.Rules
+
+	names := map[string]struct{}{}
+	for name := range oldRules {
+		names[name] = struct{}{}
+	}
+	for name := range newRules {
+		names[name] = struct{}{}
+	}
+
+	var diffs []CheckpointDiff
+	for _, name := range sortedKeys(names) {
+		oldSeries, newSeries := map[string]*series{}, map[string]*series{}
+		if s, ok := oldRules[name]; ok {
+			oldSeries = s.Series
+		}
+		if s, ok := newRules[name]; ok {
+			newSeries = s.Series
+		}
+		keys := map[string]struct{}{}
+		for key := range oldSeries {
+			keys[key] = struct{}{}
+		}
+		for key := range newSeries {
+			keys[key] = struct{}{}
+		}
+		for _, key := range sortedKeys(keys) {
+			d := CheckpointDiff{Rule: name, Key: key}
+			if s, ok := oldSeries[key]; ok {
+				d.Old = &s.Value
+			}
+			if s, ok := newSeries[key]; ok {
+				d.New = &s.Value
+			}
+			if d.Old != nil && d.New != nil && *d.Old == *d.New {
+				continue
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
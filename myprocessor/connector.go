@@ -0,0 +1,128 @@
+package simpleprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/connector"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor"
+)
+
+// Input signals of connector instances, see sharedProcessors.claim.
+const (
+	signalMetrics = "metrics"
+	signalTraces  = "traces"
+	signalLogs    = "logs"
+)
+
+// NewConnectorFactory creates a factory for the simple connector. It takes
+// the same config as the processor, consumes metrics, traces or logs and
+// flushes the aggregates into separate metrics pipelines and alerts into
+// logs pipelines.
+func NewConnectorFactory() connector.Factory {
+	return connector.NewFactory(
+		Type,
+		createDefaultConfig,
+		connector.WithMetricsToMetrics(createMetricsToMetrics, component.StabilityLevelDevelopment),
+		connector.WithTracesToMetrics(createTracesToMetrics, component.StabilityLevelDevelopment),
+		connector.WithLogsToMetrics(createLogsToMetrics, component.StabilityLevelDevelopment),
+		connector.WithMetricsToLogs(createMetricsToLogs, component.StabilityLevelDevelopment),
+		connector.WithTracesToLogs(createTracesToLogs, component.StabilityLevelDevelopment),
+		connector.WithLogsToLogs(createLogsToLogs, component.StabilityLevelDevelopment),
+	)
+}
+
+func createMetricsToMetrics(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Metrics,
+) (connector.Metrics, error) {
+	sp, shadow, err := getConnector(set, cfg, signalMetrics, nextConsumer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return metricsProcessor{sharedProcessor: sp, shadow: shadow}, nil
+}
+
+func createTracesToMetrics(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Metrics,
+) (connector.Traces, error) {
+	sp, shadow, err := getConnector(set, cfg, signalTraces, nextConsumer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return tracesProcessor{sharedProcessor: sp, shadow: shadow}, nil
+}
+
+func createLogsToMetrics(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Metrics,
+) (connector.Logs, error) {
+	sp, shadow, err := getConnector(set, cfg, signalLogs, nextConsumer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return logsProcessor{sharedProcessor: sp, shadow: shadow}, nil
+}
+
+func createMetricsToLogs(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Logs,
+) (connector.Metrics, error) {
+	sp, shadow, err := getConnector(set, cfg, signalMetrics, nil, nextConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return metricsProcessor{sharedProcessor: sp, shadow: shadow}, nil
+}
+
+func createTracesToLogs(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Logs,
+) (connector.Traces, error) {
+	sp, shadow, err := getConnector(set, cfg, signalTraces, nil, nextConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return tracesProcessor{sharedProcessor: sp, shadow: shadow}, nil
+}
+
+func createLogsToLogs(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Logs,
+) (connector.Logs, error) {
+	sp, shadow, err := getConnector(set, cfg, signalLogs, nil, nextConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return logsProcessor{sharedProcessor: sp, shadow: shadow}, nil
+}
+
+// getConnector returns the shared instance of a connector, and whether the
+// new connector instance leaves aggregating the input signal to another one.
+func getConnector(set connector.Settings, cfg component.Config, signal string, metrics consumer.Metrics, logs consumer.Logs) (*sharedProcessor, bool, error) {
+	sp, err := processors.get(processorSettings(set), component.KindConnector, cfg.(*Config), metrics, logs)
+	if err != nil {
+		return nil, false, err
+	}
+	return sp, !processors.claim(sp, signal), nil
+}
+
+// processorSettings converts connector settings, which carry the same
+// fields.
+func processorSettings(set connector.Settings) processor.Settings {
+	return processor.Settings{ID: set.ID, TelemetrySettings: set.TelemetrySettings, BuildInfo: set.BuildInfo}
+}
@@ -0,0 +1,218 @@
+package simpleprocessor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	defaultDedupWindow     = 10 * time.Minute
+	defaultDedupMaxEntries = 100_000
+)
+
+// DeduplicationConfig configures dropping data points that were already
+// aggregated, e.g. because a client retried a batch that did arrive.
+type DeduplicationConfig struct {
+	// Window is how long a data point is remembered. Retries that arrive
+	// later are aggregated again. Defaults to 10m.
+	Window time.Duration `mapstructure:"window"`
+
+	// MaxEntries bounds the number of data points remembered, the oldest are
+	// forgotten first. Every entry takes about 21 bytes in each checkpoint.
+	// Defaults to 100000.
+	MaxEntries int `mapstructure:"max_entries"`
+}
+
+// Validate checks the configuration.
+func (c *DeduplicationConfig) Validate() error {
+	if c.Window < 0 {
+		return errors.New("window must not be negative")
+	}
+	if c.MaxEntries < 0 {
+		return errors.New("max_entries must not be negative")
+	}
+	return nil
+}
+
+type dedupEntry struct {
+	hash uint64
+	seen int64
+}
+
+// dedupSet remembers the hashes of recent data points, in the order they
+// were seen.
+type dedupSet struct {
+	window     time.Duration
+	maxEntries int
+
+	seen map[uint64]struct{}
+	// order holds the hashes from the oldest, starting at head.
+	order []dedupEntry
+	head  int
+}
+
+func newDedupSet() *dedupSet {
+	return &dedupSet{seen: make(map[uint64]struct{})}
+}
+
+// configure applies the configuration, which isn't part of the checkpoint.
+func (d *dedupSet) configure(cfg *DeduplicationConfig) {
+	d.window, d.maxEntries = cfg.Window, cfg.MaxEntries
+	if d.window == 0 {
+		d.window = defaultDedupWindow
+	}
+	if d.maxEntries == 0 {
+		d.maxEntries = defaultDedupMaxEntries
+	}
+	// The checkpoint may come from a larger max_entries.
+	d.trim()
+}
+
+// contains reports whether h was seen within the window.
+func (d *dedupSet) contains(h uint64, now time.Time) bool {
+	d.expire(now)
+	_, ok := d.seen[h]
+	return ok
+}
+
+// add remembers h. It is called once the data point is aggregated, so one
+// that was rejected is aggregated when it is sent again.
+func (d *dedupSet) add(h uint64, now time.Time) {
+	if _, ok := d.seen[h]; ok {
+		return
+	}
+	d.seen[h] = struct{}{}
+	d.order = append(d.order, dedupEntry{hash: h, seen: now.UnixNano()})
+	d.trim()
+}
+
+// trim forgets the oldest hashes beyond max_entries.
+func (d *dedupSet) trim() {
+	if n := len(d.live()) - d.maxEntries; d.maxEntries > 0 && n > 0 {
+		d.forget(n)
+	}
+}
+
+// expire forgets the hashes seen before the window.
+func (d *dedupSet) expire(now time.Time) {
+	cutoff := now.Add(-d.window).UnixNano()
+	live := d.live()
+	d.forget(sort.Search(len(live), func(i int) bool { return live[i].seen > cutoff }))
+}
+
+// forget drops the n oldest hashes.
+func (d *dedupSet) forget(n int) {
+	if n == 0 {
+		return
+	}
+	for _, e := range d.order[d.head : d.head+n] {
+		delete(d.seen, e.hash)
+	}
+	d.head += n
+	// Reclaim the forgotten entries once they make up half of the slice.
+	if d.head > len(d.order)/2 {
+		d.order = d.order[:copy(d.order, d.order[d.head:])]
+		d.head = 0
+	}
+}
+
+func (d *dedupSet) live() []dedupEntry {
+	return d.order[d.head:]
+}
+
+// merge adds the hashes of other, keeping the set in the order they were seen
+// and no larger than max_entries.
+func (d *dedupSet) merge(other *dedupSet) {
+	merged := make([]dedupEntry, 0, len(d.live())+len(other.live()))
+	merged = append(merged, d.live()...)
+	for _, e := range other.live() {
+		if _, ok := d.seen[e.hash]; !ok {
+			d.seen[e.hash] = struct{}{}
+			merged = append(merged, e)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].seen < merged[j].seen })
+	d.order, d.head = merged, 0
+	d.trim()
+}
+
+func (d *dedupSet) MarshalJSON() ([]byte, error) {
+	data := make([]byte, 0, 16*len(d.live()))
+	for _, e := range d.live() {
+		data = binary.BigEndian.AppendUint64(data, e.hash)
+		data = binary.BigEndian.AppendUint64(data, uint64(e.seen))
+	}
+	return json.Marshal(data)
+}
+
+func (d *dedupSet) UnmarshalJSON(b []byte) error {
+	var data []byte
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if len(data)%16 != 0 {
+		return errors.New("deduplication set is truncated")
+	}
+	*d = *newDedupSet()
+	for i := 0; i < len(data); i += 16 {
+		e := dedupEntry{
+			hash: binary.BigEndian.Uint64(data[i:]),
+			seen: int64(binary.BigEndian.Uint64(data[i+8:])),
+		}
+		if _, ok := d.seen[e.hash]; ok {
+			continue
+		}
+		d.seen[e.hash] = struct{}{}
+		d.order = append(d.order, e)
+	}
+	sort.SliceStable(d.order, func(i, j int) bool { return d.order[i].seen < d.order[j].seen })
+	return nil
+}
+
+// dataPointHash identifies a data point by its resource, metric, attributes,
+// timestamps and value.
+func dataPointHash(resource pcommon.Resource, metric pmetric.Metric, dp pmetric.NumberDataPoint) uint64 {
+	h := fnv.New64a()
+	hashMap(h, resource.Attributes())
+	h.Write([]byte(metric.Name()))
+	h.Write([]byte{0})
+	hashMap(h, dp.Attributes())
+
+	var buf [8]byte
+	for _, v := range []uint64{
+		uint64(dp.StartTimestamp()),
+		uint64(dp.Timestamp()),
+		uint64(dp.ValueType()),
+		uint64(dp.IntValue()),
+		math.Float64bits(dp.DoubleValue()),
+	} {
+		binary.BigEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// hashMap writes the entries of m to h in key order.
+func hashMap(h hash.Hash64, m pcommon.Map) {
+	keys := make([]string, 0, m.Len())
+	for k := range m.All() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _ := m.Get(k)
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(v.AsString()))
+		h.Write([]byte{0})
+	}
+}
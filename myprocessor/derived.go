@@ -0,0 +1,151 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// DerivedConfig defines a metric computed at every flush from the series of
+// other rules, e.g. a ratio of two counters.
+type DerivedConfig struct {
+	// Name of the metric the result is flushed as.
+	Name string `mapstructure:"name"`
+
+	// Expression combines rules with + - * /, numbers and parentheses. A
+	// rule can be narrowed with matchers, e.g.
+	// work_done{status="failed"} / work_done, and rate(...) is the per second
+	// increase of its argument since the previous flush.
+	Expression string `mapstructure:"expression"`
+
+	// GroupBy lists the attributes the result is computed per. The series
+	// of every rule in the expression are summed per group, series missing
+	// any of the attributes are left out.
+	GroupBy []string `mapstructure:"group_by"`
+
+	// Unit of the flushed metric. Defaults to 1.
+	Unit string `mapstructure:"unit"`
+}
+
+func (c *DerivedConfig) unit() string {
+	if c.Unit == "" {
+		return "1"
+	}
+	return c.Unit
+}
+
+// Validate checks the derived metric on its own, validateDerived checks it
+// against the rules and other metrics.
+func (c *DerivedConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("name must be set")
+	}
+	if c.Expression == "" {
+		return errors.New("expression must be set")
+	}
+	if _, err := parseExpr(c.Name, c.Expression); err != nil {
+		return fmt.Errorf("expression: %w", err)
+	}
+	return nil
+}
+
+// validateDerived checks the derived metrics, that their expressions refer to
+// lifetime rules and that their names don't clash with those of rules.
+func validateDerived(derived []DerivedConfig, rules []RuleConfig) error {
+	if len(rules) == 0 {
+		rules = defaultRules()
+	}
+	byName := make(map[string]*RuleConfig, len(rules))
+	for i := range rules {
+		byName[rules[i].Name] = &rules[i]
+	}
+	names := make(map[string]struct{}, len(derived))
+	for i := range derived {
+		d := &derived[i]
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("derived[%d]: %w", i, err)
+		}
+		if _, ok := byName[d.Name]; ok {
+			return fmt.Errorf("derived[%d]: name %q is a rule", i, d.Name)
+		}
+		if _, ok := names[d.Name]; ok {
+			return fmt.Errorf("derived[%d]: duplicate name %q", i, d.Name)
+		}
+		names[d.Name] = struct{}{}
+		// Validated above.
+		expr, _ := parseExpr(d.Name, d.Expression)
+		for _, name := range exprRules(expr) {
+			r, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("derived[%d]: unknown rule %q", i, name)
+			}
+			if r.Window.enabled() {
+				return fmt.Errorf("derived[%d]: rule %q has a window, only lifetime rules can be combined", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// derived is a DerivedConfig ready to be computed.
+type derived struct {
+	DerivedConfig
+
+	expr exprNode
+}
+
+func newDerived(cfgs []DerivedConfig) []*derived {
+	ds := make([]*derived, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		// Validated with the config.
+		expr, _ := parseExpr(cfg.Name, cfg.Expression)
+		ds = append(ds, &derived{DerivedConfig: cfg, expr: expr})
+	}
+	return ds
+}
+
+// appendMetricLocked computes the derived metric for a tenant and adds it to
+// out as a gauge. Nothing is added when no group has a value.
+func (d *derived) appendMetricLocked(out *output, tenant string, t *tenantState, rules map[string]*rule, now time.Time) {
+	ec := &evalContext{
+		tenant:    tenant,
+		t:         t,
+		rules:     rules,
+		groupBy:   d.GroupBy,
+		now:       now,
+		attrs:     make(map[string]map[string]string),
+		resources: make(map[string]map[string]string),
+	}
+	v := d.expr.eval(ec)
+	if v.scalar {
+		// An expression of numbers only has one group without attributes.
+		v.groups = map[string]float64{"": v.v}
+	}
+	if len(v.groups) == 0 {
+		return
+	}
+
+	// Every group is flushed with the resource attributes its series share,
+	// one metric per resource.
+	metrics := make(map[string]pmetric.Metric)
+	ts := pcommon.NewTimestampFromTime(now)
+	for _, key := range sortedKeys(v.groups) {
+		resource := ec.resources[key]
+		resourceKey := seriesKey(resource)
+		m, ok := metrics[resourceKey]
+		if !ok {
+			m = out.scope(tenant, resource).Metrics().AppendEmpty()
+			m.SetName(d.Name)
+			m.SetUnit(d.unit())
+			m.SetEmptyGauge()
+			metrics[resourceKey] = m
+		}
+		dp := m.Gauge().DataPoints().AppendEmpty()
+		putAttributes(dp.Attributes(), ec.attrs[key])
+		dp.SetTimestamp(ts)
+		dp.SetDoubleValue(v.groups[key])
+	}
+}
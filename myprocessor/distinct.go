@@ -0,0 +1,79 @@
+package simpleprocessor
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/axiomhq/hyperloglog"
+)
+
+const defaultHLLPrecision = 14
+
+// DistinctCountConfig configures the distinct_count aggregation.
+type DistinctCountConfig struct {
+	// Attribute whose distinct values are counted per group, e.g. work.id.
+	Attribute string `mapstructure:"attribute"`
+
+	// Precision of the HyperLogLog sketch, between 4 and 18. Higher is more
+	// accurate and uses 2^precision bytes per series. Defaults to 14, about
+	// 0.8% standard error.
+	Precision uint8 `mapstructure:"precision"`
+}
+
+// Validate checks the configuration.
+func (c *DistinctCountConfig) Validate() error {
+	if c.Attribute == "" {
+		return errors.New("attribute must be set")
+	}
+	if c.Precision != 0 && (c.Precision < 4 || c.Precision > 18) {
+		return errors.New("precision must be between 4 and 18")
+	}
+	return nil
+}
+
+// hllSketch is a HyperLogLog sketch that is checkpointed in its binary form.
+type hllSketch struct {
+	sketch *hyperloglog.Sketch
+}
+
+func newHLLSketch(precision uint8) *hllSketch {
+	if precision == 0 {
+		precision = defaultHLLPrecision
+	}
+	sk, err := hyperloglog.NewSketch(precision, true)
+	if err != nil {
+		// Precision is validated with the config.
+		panic(err)
+	}
+	return &hllSketch{sketch: sk}
+}
+
+func (h *hllSketch) insert(v string) {
+	h.sketch.Insert([]byte(v))
+}
+
+func (h *hllSketch) estimate() uint64 {
+	return h.sketch.Estimate()
+}
+
+// merge folds other into h, so h counts the union of both.
+func (h *hllSketch) merge(other *hllSketch) error {
+	return h.sketch.Merge(other.sketch)
+}
+
+func (h *hllSketch) MarshalJSON() ([]byte, error) {
+	data, err := h.sketch.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func (h *hllSketch) UnmarshalJSON(b []byte) error {
+	var data []byte
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	h.sketch = hyperloglog.New()
+	return h.sketch.UnmarshalBinary(data)
+}
@@ -0,0 +1,349 @@
+package simpleprocessor
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // SQLite lookup tables, pure Go so no cgo is needed
+)
+
+// Formats of lookup tables.
+const (
+	// EnrichmentFormatCSV is a CSV file with a header row naming the columns.
+	EnrichmentFormatCSV = "csv"
+	// EnrichmentFormatJSON is a JSON array of objects, one per row.
+	EnrichmentFormatJSON = "json"
+	// EnrichmentFormatSQLite is a table of an SQLite database.
+	EnrichmentFormatSQLite = "sqlite"
+)
+
+const defaultEnrichmentReloadInterval = 10 * time.Second
+
+// EnrichmentConfig joins a data point attribute against a lookup table and
+// adds the columns of the matching row as attributes, before aggregation.
+type EnrichmentConfig struct {
+	// Path of the lookup table.
+	Path string `mapstructure:"path"`
+
+	// Format is csv, json or sqlite. Defaults to the extension of path,
+	// with .db and .sqlite3 being sqlite.
+	Format string `mapstructure:"format"`
+
+	// Table is the table of an SQLite database.
+	Table string `mapstructure:"table"`
+
+	// Attribute is the data point attribute looked up, e.g. work.type.
+	Attribute string `mapstructure:"attribute"`
+
+	// Key is the column the attribute is matched against. Defaults to the
+	// attribute.
+	Key string `mapstructure:"key"`
+
+	// Columns maps the columns to add to the attributes they are added as,
+	// e.g. team: work.team. Empty adds every column but the key under its
+	// own name.
+	Columns map[string]string `mapstructure:"columns"`
+
+	// ReloadInterval is how often the file is checked for changes. Defaults
+	// to 10s.
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+}
+
+func (c *EnrichmentConfig) format() string {
+	if c.Format != "" {
+		return c.Format
+	}
+	switch strings.ToLower(filepath.Ext(c.Path)) {
+	case ".json":
+		return EnrichmentFormatJSON
+	case ".db", ".sqlite", ".sqlite3":
+		return EnrichmentFormatSQLite
+	default:
+		return EnrichmentFormatCSV
+	}
+}
+
+func (c *EnrichmentConfig) key() string {
+	if c.Key == "" {
+		return c.Attribute
+	}
+	return c.Key
+}
+
+func (c *EnrichmentConfig) reloadInterval() time.Duration {
+	if c.ReloadInterval == 0 {
+		return defaultEnrichmentReloadInterval
+	}
+	return c.ReloadInterval
+}
+
+// Validate checks the configuration.
+func (c *EnrichmentConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path must be set")
+	}
+	if c.Attribute == "" {
+		return errors.New("attribute must be set")
+	}
+	switch c.format() {
+	case EnrichmentFormatCSV, EnrichmentFormatJSON:
+		if c.Table != "" {
+			return errors.New("table only applies to sqlite")
+		}
+	case EnrichmentFormatSQLite:
+		if c.Table == "" {
+			return errors.New("table must be set for sqlite")
+		}
+	default:
+		return errors.New("format must be csv, json or sqlite")
+	}
+	if c.ReloadInterval < 0 {
+		return errors.New("reload_interval must not be negative")
+	}
+	return nil
+}
+
+// lookupTable is a loaded lookup table: the attributes to add by key.
+type lookupTable map[string]map[string]string
+
+// enrichment is a lookup table and the state of reloading it.
+type enrichment struct {
+	cfg     *EnrichmentConfig
+	table   atomic.Pointer[lookupTable]
+	modTime time.Time
+	size    int64
+}
+
+func newEnrichments(cfgs []EnrichmentConfig) []*enrichment {
+	es := make([]*enrichment, 0, len(cfgs))
+	for i := range cfgs {
+		es = append(es, &enrichment{cfg: &cfgs[i]})
+	}
+	return es
+}
+
+// apply adds the attributes of the row matching attrs. Attributes the data
+// point already has are kept.
+func (e *enrichment) apply(attrs pcommon.Map) {
+	table := e.table.Load()
+	if table == nil {
+		return
+	}
+	v, ok := attrs.Get(e.cfg.Attribute)
+	if !ok {
+		return
+	}
+	for k, v := range (*table)[v.AsString()] {
+		if _, ok := attrs.Get(k); !ok {
+			attrs.PutStr(k, v)
+		}
+	}
+}
+
+// load reads the table if the file changed since it was last read. It
+// reports whether it did.
+func (e *enrichment) load() (bool, error) {
+	info, err := os.Stat(e.cfg.Path)
+	if err != nil {
+		return false, err
+	}
+	if e.table.Load() != nil && info.ModTime().Equal(e.modTime) && info.Size() == e.size {
+		return false, nil
+	}
+	var rows []map[string]string
+	switch e.cfg.format() {
+	case EnrichmentFormatJSON:
+		rows, err = loadJSONRows(e.cfg.Path)
+	case EnrichmentFormatSQLite:
+		rows, err = loadSQLiteRows(e.cfg.Path, e.cfg.Table)
+	default:
+		rows, err = loadCSVRows(e.cfg.Path)
+	}
+	if err != nil {
+		return false, err
+	}
+	table, err := e.lookupTable(rows)
+	if err != nil {
+		return false, err
+	}
+	e.table.Store(&table)
+	e.modTime, e.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// lookupTable indexes rows by key and renames their columns.
+func (e *enrichment) lookupTable(rows []map[string]string) (lookupTable, error) {
+	key := e.cfg.key()
+	table := make(lookupTable, len(rows))
+	for i, row := range rows {
+		k, ok := row[key]
+		if !ok {
+			return nil, fmt.Errorf("row %d has no %q column", i+1, key)
+		}
+		attrs := make(map[string]string)
+		if len(e.cfg.Columns) == 0 {
+			for column, v := range row {
+				if column != key {
+					attrs[column] = v
+				}
+			}
+		} else {
+			for column, attr := range e.cfg.Columns {
+				if v, ok := row[column]; ok {
+					attrs[attr] = v
+				}
+			}
+		}
+		table[k] = attrs
+	}
+	return table, nil
+}
+
+func loadCSVRows(path string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	var rows []map[string]string
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+func loadJSONRows(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]any
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(objects))
+	for _, object := range objects {
+		row := make(map[string]string, len(object))
+		for k, v := range object {
+			switch v := v.(type) {
+			case string:
+				row[k] = v
+			case float64:
+				row[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				row[k] = strconv.FormatBool(v)
+			case nil:
+			default:
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				row[k] = string(encoded)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func loadSQLiteRows(path, table string) ([]map[string]string, error) {
+	db, err := sql.Open("sqlite", "file:"+url.PathEscape(path)+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rs, err := db.Query(`SELECT * FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	columns, err := rs.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]string
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rs.Next() {
+		if err := rs.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			if values[i].Valid {
+				row[column] = values[i].String
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, rs.Err()
+}
+
+// startEnrichment loads the lookup tables and reloads them when their files
+// change until the processor shuts down. A table that fails to reload keeps
+// its previous rows.
+func (p *simpleProcessor) startEnrichment() error {
+	for _, e := range p.enrichments {
+		if _, err := e.load(); err != nil {
+			return fmt.Errorf("failed to load lookup table %q: %w", e.cfg.Path, err)
+		}
+		go p.reloadLoop(e)
+	}
+	return nil
+}
+
+func (p *simpleProcessor) reloadLoop(e *enrichment) {
+	ticker := time.NewTicker(e.cfg.reloadInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			reloaded, err := e.load()
+			if err != nil {
+				p.logger.Warn("Failed to reload lookup table, keeping the previous one", zap.String("path", e.cfg.Path), zap.Error(err))
+			} else if reloaded {
+				p.logger.Info("Reloaded lookup table", zap.String("path", e.cfg.Path), zap.Int("rows", len(*e.table.Load())))
+			}
+		}
+	}
+}
+
+// enrich adds the attributes of every lookup table to a data point.
+func (p *simpleProcessor) enrich(attrs pcommon.Map) {
+	for _, e := range p.enrichments {
+		e.apply(attrs)
+	}
+}
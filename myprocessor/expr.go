@@ -0,0 +1,440 @@
+package simpleprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// exprNode is a node of a derived expression.
+type exprNode interface {
+	eval(ec *evalContext) exprValue
+}
+
+// exprValue is the value of an expression: a number, or a value per group.
+type exprValue struct {
+	scalar bool
+	v      float64
+	groups map[string]float64 // Value by group key
+}
+
+// exprFunctions are the functions of derived expressions. Rules can't be
+// named after them.
+var exprFunctions = map[string]struct{}{
+	"rate": {},
+}
+
+// evalContext is what an expression is evaluated against: one tenant's
+// rules at one flush.
+type evalContext struct {
+	tenant  string
+	t       *tenantState
+	rules   map[string]*rule
+	groupBy []string
+	now     time.Time
+
+	// attrs are the attributes of every group key seen.
+	attrs map[string]map[string]string
+	// resources are the resource attributes every series of a group
+	// shares, by group key.
+	resources map[string]map[string]string
+}
+
+// addResource narrows the resource of a group down to the attributes it
+// shares with a series of the group.
+func (ec *evalContext) addResource(key string, resource map[string]string) {
+	shared, ok := ec.resources[key]
+	if !ok {
+		shared = make(map[string]string, len(resource))
+		for k, v := range resource {
+			shared[k] = v
+		}
+		ec.resources[key] = shared
+		return
+	}
+	for k, v := range shared {
+		if resource[k] != v {
+			delete(shared, k)
+		}
+	}
+}
+
+type numberNode float64
+
+func (n numberNode) eval(*evalContext) exprValue {
+	return exprValue{scalar: true, v: float64(n)}
+}
+
+// selectorNode sums the series of a rule with the matched attributes per
+// group.
+type selectorNode struct {
+	rule     string
+	matchers map[string]string
+}
+
+func (s *selectorNode) eval(ec *evalContext) exprValue {
+	groups := make(map[string]float64)
+	state, ok := ec.t.rules[s.rule]
+	if !ok {
+		return exprValue{groups: groups}
+	}
+	r := ec.rules[s.rule]
+	for _, sr := range state.Series {
+		if !sr.matches(s.matchers) {
+			continue
+		}
+		attrs := make(map[string]string, len(ec.groupBy))
+		missing := false
+		for _, k := range ec.groupBy {
+			v, ok := sr.Attributes[k]
+			if !ok {
+				v, ok = sr.Resource[k]
+			}
+			if !ok {
+				missing = true
+				break
+			}
+			attrs[k] = v
+		}
+		if missing {
+			continue
+		}
+		key := seriesKey(attrs)
+		ec.attrs[key] = attrs
+		ec.addResource(key, sr.Resource)
+		groups[key] += r.seriesValue(sr)
+	}
+	return exprValue{groups: groups}
+}
+
+type unaryMinusNode struct {
+	x exprNode
+}
+
+func (u unaryMinusNode) eval(ec *evalContext) exprValue {
+	return apply(exprValue{scalar: true, v: -1}, u.x.eval(ec), '*')
+}
+
+type binaryNode struct {
+	op   byte
+	x, y exprNode
+}
+
+func (b binaryNode) eval(ec *evalContext) exprValue {
+	return apply(b.x.eval(ec), b.y.eval(ec), b.op)
+}
+
+// apply combines two values. A number applies to every group, groups are
+// matched by key and those missing on either side are left out, as are
+// divisions by zero.
+func apply(x, y exprValue, op byte) exprValue {
+	if x.scalar && y.scalar {
+		v, ok := arith(x.v, y.v, op)
+		if !ok {
+			// Groups of the same expression would be left out too.
+			return exprValue{groups: map[string]float64{}}
+		}
+		return exprValue{scalar: true, v: v}
+	}
+	groups := make(map[string]float64)
+	switch {
+	case x.scalar:
+		for k, yv := range y.groups {
+			if v, ok := arith(x.v, yv, op); ok {
+				groups[k] = v
+			}
+		}
+	case y.scalar:
+		for k, xv := range x.groups {
+			if v, ok := arith(xv, y.v, op); ok {
+				groups[k] = v
+			}
+		}
+	default:
+		for k, xv := range x.groups {
+			yv, ok := y.groups[k]
+			if !ok {
+				continue
+			}
+			if v, ok := arith(xv, yv, op); ok {
+				groups[k] = v
+			}
+		}
+	}
+	return exprValue{groups: groups}
+}
+
+func arith(x, y float64, op byte) (float64, bool) {
+	switch op {
+	case '+':
+		return x + y, true
+	case '-':
+		return x - y, true
+	case '*':
+		return x * y, true
+	default:
+		if y == 0 {
+			return 0, false
+		}
+		return x / y, true
+	}
+}
+
+// rateNode is the per second increase of an expression between two flushes.
+// The previous values are kept per tenant under id and checkpointed, so the
+// rate carries on across restarts.
+type rateNode struct {
+	id string
+	x  exprNode
+}
+
+func (r *rateNode) eval(ec *evalContext) exprValue {
+	x := r.x.eval(ec)
+	if x.scalar {
+		return exprValue{scalar: true}
+	}
+	prev := ec.t.rates[r.id]
+	next := make(map[string]alertSample, len(x.groups))
+	groups := make(map[string]float64)
+	for k, v := range x.groups {
+		next[k] = alertSample{Time: ec.now, Value: v}
+		p, ok := prev[k]
+		elapsed := ec.now.Sub(p.Time).Seconds()
+		if !ok || elapsed <= 0 {
+			continue
+		}
+		increase := v - p.Value
+		if increase < 0 {
+			// The series was reset and counted up from zero since.
+			increase = v
+		}
+		groups[k] = increase / elapsed
+	}
+	if ec.t.rates == nil {
+		ec.t.rates = make(map[string]map[string]alertSample)
+	}
+	ec.t.rates[r.id] = next
+	return exprValue{groups: groups}
+}
+
+// parseExpr parses a derived expression. Operands are numbers, rule names
+// with optional matchers, e.g. work_done{work.type="failed"}, and rate(...),
+// combined with + - * / and parentheses. The rates of the expression keep
+// their previous values under name/<n>, n counting them from 0.
+func parseExpr(name, s string) (exprNode, error) {
+	p := &exprParser{name: name, s: s}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	return n, nil
+}
+
+type exprParser struct {
+	name  string
+	s     string
+	pos   int
+	rates int // rate() calls parsed so far
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next character after spaces, or 0 at the end.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *exprParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryNode{op: c, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '*' || c == '/'; c = p.peek() {
+		p.pos++
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryNode{op: c, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryMinusNode{x}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(')')
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && (isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return numberNode(v), nil
+	case isIdentStart(c):
+		name := p.ident()
+		if name == "rate" && p.peek() == '(' {
+			p.pos++
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			id := p.name + "/" + strconv.Itoa(p.rates)
+			p.rates++
+			return &rateNode{id: id, x: x}, p.expect(')')
+		}
+		sel := &selectorNode{rule: name}
+		if p.peek() == '{' {
+			p.pos++
+			matchers, err := p.matchers()
+			if err != nil {
+				return nil, err
+			}
+			sel.matchers = matchers
+		}
+		return sel, nil
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// matchers parses the matchers of a selector after the opening brace.
+func (p *exprParser) matchers() (map[string]string, error) {
+	matchers := make(map[string]string)
+	for p.peek() != '}' {
+		if len(matchers) > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		if !isIdentStart(p.peek()) {
+			return nil, p.errorf("expected an attribute name")
+		}
+		key := p.ident()
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		if p.peek() != '"' {
+			return nil, p.errorf("expected a quoted value")
+		}
+		value, err := strconv.QuotedPrefix(p.s[p.pos:])
+		if err != nil {
+			return nil, p.errorf("invalid value: %v", err)
+		}
+		p.pos += len(value)
+		matchers[key], _ = strconv.Unquote(value)
+	}
+	p.pos++
+	return matchers, nil
+}
+
+// ident reads a rule or attribute name, which may contain dots.
+func (p *exprParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) && (isIdentStart(p.s[p.pos]) || isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// exprRules returns the rules an expression refers to.
+func exprRules(n exprNode) []string {
+	switch n := n.(type) {
+	case *selectorNode:
+		return []string{n.rule}
+	case unaryMinusNode:
+		return exprRules(n.x)
+	case binaryNode:
+		return append(exprRules(n.x), exprRules(n.y)...)
+	case *rateNode:
+		return exprRules(n.x)
+	default:
+		return nil
+	}
+}
+
+// exprRates returns the ids of the rates of an expression.
+func exprRates(n exprNode) []string {
+	switch n := n.(type) {
+	case unaryMinusNode:
+		return exprRates(n.x)
+	case binaryNode:
+		return append(exprRates(n.x), exprRates(n.y)...)
+	case *rateNode:
+		return append(exprRates(n.x), n.id)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,267 @@
+package simpleprocessor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		expr    string
+		rules   []string
+		wantErr bool
+	}{
+		{expr: "work_done", rules: []string{"work_done"}},
+		{expr: "42", rules: nil},
+		{expr: ".5 * work_done", rules: []string{"work_done"}},
+		{expr: `work_done{work.type="failed"} / work_done`, rules: []string{"work_done", "work_done"}},
+		{expr: `work_done{a="1", b="2"}`, rules: []string{"work_done"}},
+		{expr: "-(a + b) * c", rules: []string{"a", "b", "c"}},
+		{expr: "rate(work_done) / rate(work_started)", rules: []string{"work_done", "work_started"}},
+		{expr: "rate (work_done)", rules: []string{"work_done"}},
+		{expr: "", wantErr: true},
+		{expr: "work_done +", wantErr: true},
+		{expr: "(work_done", wantErr: true},
+		{expr: "work_done)", wantErr: true},
+		{expr: "rate(work_done", wantErr: true},
+		{expr: "work_done{work.type=failed}", wantErr: true},
+		{expr: `work_done{work.type="failed"`, wantErr: true},
+		{expr: `work_done{="failed"}`, wantErr: true},
+		{expr: "1..2", wantErr: true},
+		{expr: "work_done % 2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			n, err := parseExpr("derived", tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseExpr(%q) succeeded, want an error", tt.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseExpr(%q): %v", tt.expr, err)
+			}
+			got := exprRules(n)
+			if len(got) != len(tt.rules) {
+				t.Fatalf("rules = %v, want %v", got, tt.rules)
+			}
+			for i := range got {
+				if got[i] != tt.rules[i] {
+					t.Fatalf("rules = %v, want %v", got, tt.rules)
+				}
+			}
+		})
+	}
+}
+
+func TestParseExprRateIDs(t *testing.T) {
+	n, err := parseExpr("ratio", "rate(a) / rate(rate(b))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := exprRates(n)
+	want := []string{"ratio/0", "ratio/1", "ratio/2"}
+	if len(got) != len(want) {
+		t.Fatalf("rates = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("rates = %v, want %v", got, want)
+		}
+	}
+}
+
+// exprTenant returns a tenant with the given values of work_done and
+// work_started, by work.type.
+func exprTenant(done, started map[string]int64) *tenantState {
+	t := &tenantState{rules: make(map[string]*ruleState)}
+	for name, values := range map[string]map[string]int64{"work_done": done, "work_started": started} {
+		state := newRuleState()
+		for workType, v := range values {
+			attrs := map[string]string{"work.type": workType}
+			state.Series[seriesKey(attrs)] = &series{Attributes: attrs, aggregate: aggregate{Value: v}}
+		}
+		t.rules[name] = state
+	}
+	return t
+}
+
+func evalExpr(t *testing.T, expr string, tenant *tenantState, groupBy []string, now time.Time) exprValue {
+	t.Helper()
+	n, err := parseExpr("derived", expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := make(map[string]*rule)
+	for _, r := range newRules([]RuleConfig{{Name: "work_done"}, {Name: "work_started"}}) {
+		rules[r.Name] = r
+	}
+	return n.eval(&evalContext{
+		t:         tenant,
+		rules:     rules,
+		groupBy:   groupBy,
+		now:       now,
+		attrs:     make(map[string]map[string]string),
+		resources: make(map[string]map[string]string),
+	})
+}
+
+func TestExprEval(t *testing.T) {
+	tenant := exprTenant(
+		map[string]int64{"manual": 3, "auto": 6, "failed": 1},
+		map[string]int64{"manual": 4, "auto": 0},
+	)
+	manual := seriesKey(map[string]string{"work.type": "manual"})
+	auto := seriesKey(map[string]string{"work.type": "auto"})
+	tests := []struct {
+		name    string
+		expr    string
+		groupBy []string
+		scalar  float64
+		groups  map[string]float64
+	}{
+		{name: "numbers", expr: "1 + 2 * 3", scalar: 7},
+		{name: "parentheses", expr: "(1 + 2) * 3", scalar: 9},
+		{name: "unary minus", expr: "-2 - -3", scalar: 1},
+		{name: "sum over all series", expr: "work_done", groups: map[string]float64{"": 10}},
+		{name: "matchers", expr: `work_done{work.type="manual"} * 2`, groups: map[string]float64{"": 6}},
+		{name: "unknown matcher value", expr: `work_done{work.type="none"}`, groups: map[string]float64{}},
+		{
+			name:    "ratio per group leaves out missing groups and division by zero",
+			expr:    "work_done / work_started",
+			groupBy: []string{"work.type"},
+			groups:  map[string]float64{manual: 0.75},
+		},
+		{
+			name:    "scalar applies to every group",
+			expr:    "100 - work_done",
+			groupBy: []string{"work.type"},
+			groups: map[string]float64{
+				manual: 97,
+				auto:   94,
+				seriesKey(map[string]string{"work.type": "failed"}): 99,
+			},
+		},
+		{name: "scalar division by zero", expr: "1 / 0", groups: map[string]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := evalExpr(t, tt.expr, tenant, tt.groupBy, time.Now())
+			if tt.groups == nil {
+				if !v.scalar || v.v != tt.scalar {
+					t.Fatalf("got %+v, want scalar %g", v, tt.scalar)
+				}
+				return
+			}
+			if v.scalar {
+				t.Fatalf("got scalar %g, want groups %v", v.v, tt.groups)
+			}
+			if len(v.groups) != len(tt.groups) {
+				t.Fatalf("groups = %v, want %v", v.groups, tt.groups)
+			}
+			for k, want := range tt.groups {
+				if got, ok := v.groups[k]; !ok || math.Abs(got-want) > 1e-9 {
+					t.Fatalf("groups = %v, want %v", v.groups, tt.groups)
+				}
+			}
+		})
+	}
+}
+
+func TestExprRate(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name  string
+		flush []int64 // work_done at each flush, 10s apart
+		want  []float64
+	}{
+		{name: "increase", flush: []int64{10, 30, 30}, want: []float64{math.NaN(), 2, 0}},
+		{name: "counter reset", flush: []int64{100, 20, 50}, want: []float64{math.NaN(), 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant := exprTenant(nil, nil)
+			for i, v := range tt.flush {
+				tenant.rules["work_done"] = exprTenant(map[string]int64{"manual": v}, nil).rules["work_done"]
+				got := evalExpr(t, "rate(work_done)", tenant, nil, start.Add(time.Duration(i)*10*time.Second))
+				rate, ok := got.groups[""]
+				if math.IsNaN(tt.want[i]) {
+					if ok {
+						t.Fatalf("flush %d: rate = %g, want none", i, rate)
+					}
+					continue
+				}
+				if !ok || rate != tt.want[i] {
+					t.Fatalf("flush %d: rate = %g (%v), want %g", i, rate, ok, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExprRateSurvivesCheckpoint(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tenant := exprTenant(map[string]int64{"manual": 10}, nil)
+	evalExpr(t, "rate(work_done)", tenant, nil, start)
+
+	data, err := encodeCheckpoint(&checkpoint{Rules: tenant.rules, Rates: tenant.rates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := decodeCheckpoint(data, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := exprTenant(map[string]int64{"manual": 40}, nil)
+	restored.rates = cp.Rates
+	got := evalExpr(t, "rate(work_done)", restored, nil, start.Add(10*time.Second))
+	if rate, ok := got.groups[""]; !ok || rate != 3 {
+		t.Fatalf("rate after restore = %g (%v), want 3", rate, ok)
+	}
+}
+
+func TestDerivedKeepsSharedResource(t *testing.T) {
+	tenant := exprTenant(nil, nil)
+	state := tenant.rules["work_done"]
+	for _, s := range []*series{
+		{Attributes: map[string]string{"work.type": "manual"}, Resource: map[string]string{"service.name": "a", "host.name": "h1"}, aggregate: aggregate{Value: 1}},
+		{Attributes: map[string]string{"work.type": "manual"}, Resource: map[string]string{"service.name": "a", "host.name": "h2"}, aggregate: aggregate{Value: 2}},
+		{Attributes: map[string]string{"work.type": "auto"}, Resource: map[string]string{"service.name": "b"}, aggregate: aggregate{Value: 4}},
+	} {
+		state.Series[resourceSeriesKey(s.Resource, s.Attributes)] = s
+	}
+	d := newDerived([]DerivedConfig{{Name: "doubled", Expression: "work_done * 2", GroupBy: []string{"work.type"}}})[0]
+	rules := make(map[string]*rule)
+	for _, r := range newRules([]RuleConfig{{Name: "work_done"}, {Name: "work_started"}}) {
+		rules[r.Name] = r
+	}
+	out := newOutput(nil, nil)
+	d.appendMetricLocked(out, "", tenant, rules, time.Now())
+
+	got := make(map[string]string)
+	rms := out.md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		service, _ := rm.Resource().Attributes().Get("service.name")
+		if _, ok := rm.Resource().Attributes().Get("host.name"); ok {
+			t.Fatalf("resource %v keeps an attribute its series don't share", rm.Resource().Attributes().AsRaw())
+		}
+		dps := rm.ScopeMetrics().At(0).Metrics().At(0).Gauge().DataPoints()
+		for j := 0; j < dps.Len(); j++ {
+			workType, _ := dps.At(j).Attributes().Get("work.type")
+			got[workType.Str()] = service.Str()
+		}
+	}
+	if got["manual"] != "a" || got["auto"] != "b" || len(got) != 2 {
+		t.Fatalf("service.name by work.type = %v, want manual: a, auto: b", got)
+	}
+}
+
+func TestRuleNamedAfterFunction(t *testing.T) {
+	r := RuleConfig{Name: "rate"}
+	if err := r.Validate(); err == nil {
+		t.Fatal("a rule named rate validated")
+	}
+}
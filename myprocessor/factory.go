@@ -2,6 +2,8 @@ package simpleprocessor
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
@@ -18,17 +20,192 @@ func NewFactory() processor.Factory {
 		Type,
 		createDefaultConfig,
 		processor.WithMetrics(createMetricsProcessor, component.StabilityLevelDevelopment),
+		processor.WithTraces(createTracesProcessor, component.StabilityLevelDevelopment),
+		processor.WithLogs(createLogsProcessor, component.StabilityLevelDevelopment),
 	)
 }
 
 func createDefaultConfig() component.Config {
-	return &Config{}
+	return &Config{
+		StorageFailure: StorageFailureConfig{
+			Policy: StorageFailureFail,
+			Retry: RetryConfig{
+				InitialInterval: time.Second,
+				MaxInterval:     30 * time.Second,
+				MaxElapsedTime:  5 * time.Minute,
+			},
+		},
+	}
 }
 
 // Config represents the configuration for the simple processor.
 type Config struct {
+	// Rules define the aggregations. Without rules, counters are summed by
+	// work.type into work_done_batched.
+	Rules []RuleConfig `mapstructure:"rules"`
+
+	// Alerts are checked against the series of the rules on every flush.
+	// Series that start or stop firing are emitted as log records through
+	// the logs output of the connector.
+	Alerts []AlertConfig `mapstructure:"alerts"`
+
+	// Derived are metrics computed from the series of the rules on every
+	// flush, such as ratios and rates, and flushed as gauges.
+	Derived []DerivedConfig `mapstructure:"derived"`
+
+	// Recording evaluates recording rules, a subset of PromQL, against the
+	// samples of the last flushes and flushes their results as gauges.
+	Recording *RecordingConfig `mapstructure:"recording"`
+
+	// Enrichment joins data point attributes against lookup tables before
+	// aggregation, adding the columns of the matching rows as attributes.
+	Enrichment []EnrichmentConfig `mapstructure:"enrichment"`
+
+	// Quarantine routes the data points rules reject as invalid, with the
+	// reason, to the logs output or the debug log.
+	Quarantine *QuarantineConfig `mapstructure:"quarantine"`
+
 	CheckpointFile string        `mapstructure:"checkpoint_file"`
 	StorageID      *component.ID `mapstructure:"storage"`
+
+	// StorageFailure decides what happens when the storage extension can't
+	// be read or written.
+	StorageFailure StorageFailureConfig `mapstructure:"storage_failure"`
+
+	// Introspection enables an HTTP endpoint showing the live state.
+	Introspection *IntrospectionConfig `mapstructure:"introspection"`
+
+	// CollectorIdentity stamps the collector's own resource attributes, such
+	// as service.name, onto every flushed resource with a collector. prefix.
+	CollectorIdentity bool `mapstructure:"collector_identity"`
+
+	// Deduplication drops data points that were already aggregated, such as
+	// those of a retried batch.
+	Deduplication *DeduplicationConfig `mapstructure:"deduplication"`
+
+	// Tenancy aggregates every tenant on its own, with separate state,
+	// checkpoints and series limits.
+	Tenancy *TenancyConfig `mapstructure:"tenancy"`
+}
+
+// Storage failure policies.
+const (
+	// StorageFailureContinue logs that the state can't be loaded and starts
+	// from empty state. The stored state isn't overwritten until it has been
+	// read and merged.
+	StorageFailureContinue = "continue"
+	// StorageFailureFail makes Start return an error if the state can't be
+	// loaded. It is the default.
+	StorageFailureFail = "fail"
+	// StorageFailureRetry retries loading the state with backoff before failing.
+	StorageFailureRetry = "retry"
+	// StorageFailureFallback degrades to checkpoint_file while the storage
+	// extension is unavailable and reconciles both once it is back.
+	StorageFailureFallback = "fallback"
+)
+
+// StorageFailureConfig configures the storage failure policy.
+type StorageFailureConfig struct {
+	Policy string      `mapstructure:"policy"`
+	Retry  RetryConfig `mapstructure:"retry"`
+}
+
+func (c *StorageFailureConfig) policy() string {
+	if c.Policy == "" {
+		return StorageFailureFail
+	}
+	return c.Policy
+}
+
+// RetryConfig configures the backoff used by the retry policy.
+type RetryConfig struct {
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+	MaxInterval     time.Duration `mapstructure:"max_interval"`
+	MaxElapsedTime  time.Duration `mapstructure:"max_elapsed_time"`
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if err := validateRules(c.Rules); err != nil {
+		return err
+	}
+	if err := validateAlerts(c.Alerts, c.Rules); err != nil {
+		return err
+	}
+	if err := validateDerived(c.Derived, c.Rules); err != nil {
+		return err
+	}
+	if c.Recording != nil {
+		if err := c.Recording.Validate(); err != nil {
+			return fmt.Errorf("recording: %w", err)
+		}
+		if err := c.validateRecordNames(); err != nil {
+			return err
+		}
+	}
+	for i := range c.Enrichment {
+		if err := c.Enrichment[i].Validate(); err != nil {
+			return fmt.Errorf("enrichment[%d]: %w", i, err)
+		}
+	}
+	if c.Quarantine != nil {
+		if err := c.Quarantine.Validate(); err != nil {
+			return fmt.Errorf("quarantine: %w", err)
+		}
+	}
+	switch c.StorageFailure.policy() {
+	case StorageFailureContinue, StorageFailureFail, StorageFailureRetry:
+	case StorageFailureFallback:
+		if c.StorageID == nil || c.CheckpointFile == "" {
+			return fmt.Errorf("storage_failure policy %q needs both storage and checkpoint_file", StorageFailureFallback)
+		}
+	default:
+		return fmt.Errorf("unknown storage_failure policy %q", c.StorageFailure.Policy)
+	}
+	if c.StorageFailure.Policy == StorageFailureRetry && c.StorageFailure.Retry.InitialInterval <= 0 {
+		return fmt.Errorf("storage_failure.retry.initial_interval must be positive")
+	}
+	if c.Introspection != nil {
+		if err := c.Introspection.Validate(); err != nil {
+			return fmt.Errorf("introspection: %w", err)
+		}
+		if c.Introspection.TenantAuthAttribute != "" && c.Tenancy == nil {
+			return fmt.Errorf("introspection.tenant_auth_attribute needs tenancy")
+		}
+	}
+	if c.Deduplication != nil {
+		if err := c.Deduplication.Validate(); err != nil {
+			return fmt.Errorf("deduplication: %w", err)
+		}
+	}
+	if c.Tenancy != nil {
+		if err := c.Tenancy.Validate(); err != nil {
+			return fmt.Errorf("tenancy: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateRecordNames checks that recording rules don't flush metrics named
+// like rules or derived metrics.
+func (c *Config) validateRecordNames() error {
+	rules := c.Rules
+	if len(rules) == 0 {
+		rules = defaultRules()
+	}
+	names := make(map[string]struct{})
+	for _, r := range rules {
+		names[r.Name] = struct{}{}
+	}
+	for _, d := range c.Derived {
+		names[d.Name] = struct{}{}
+	}
+	for i, r := range c.Recording.Rules {
+		if _, ok := names[r.Record]; ok {
+			return fmt.Errorf("recording: rules[%d]: record %q is already flushed by a rule or derived metric", i, r.Record)
+		}
+	}
+	return nil
 }
 
 func createMetricsProcessor(
@@ -37,6 +214,40 @@ func createMetricsProcessor(
 	cfg component.Config,
 	nextConsumer consumer.Metrics,
 ) (processor.Metrics, error) {
-	config := cfg.(*Config)
-	return newProcessor(set.Logger, nextConsumer, config.CheckpointFile, config.StorageID, set.ID), nil
+	sp, err := processors.get(set, component.KindProcessor, cfg.(*Config), nextConsumer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return metricsProcessor{sharedProcessor: sp}, nil
+}
+
+// createTracesProcessor creates the processor for a traces pipeline. It
+// shares its state with the processor of the same config in metrics
+// pipelines, which flush what the spans rules aggregate.
+func createTracesProcessor(
+	_ context.Context,
+	set processor.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Traces,
+) (processor.Traces, error) {
+	sp, err := processors.get(set, component.KindProcessor, cfg.(*Config), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return tracesProcessor{sharedProcessor: sp, next: nextConsumer}, nil
+}
+
+// createLogsProcessor creates the processor for a logs pipeline. Like the
+// traces processor, it shares its state with the metrics pipelines.
+func createLogsProcessor(
+	_ context.Context,
+	set processor.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Logs,
+) (processor.Logs, error) {
+	sp, err := processors.get(set, component.KindProcessor, cfg.(*Config), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return logsProcessor{sharedProcessor: sp, next: nextConsumer}, nil
 }
@@ -0,0 +1,93 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+)
+
+// Failure sources tracked by the health reporter.
+const (
+	healthLoad                = "load"
+	healthCheckpoint          = "checkpoint"
+	healthCheckpointSecondary = "checkpoint_secondary"
+	healthDegraded            = "degraded"
+	healthFlush               = "flush"
+)
+
+// healthReporter turns failures of the processor's background work into
+// component status events. The processor is recoverable while any source is
+// failing and OK again once all of them have recovered. Corrupt state is
+// permanent, the collector's status state machine does not leave it.
+type healthReporter struct {
+	mu        sync.Mutex
+	host      component.Host
+	failures  map[string]error
+	permanent bool
+}
+
+func newHealthReporter() *healthReporter {
+	return &healthReporter{failures: make(map[string]error)}
+}
+
+func (h *healthReporter) setHost(host component.Host) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.host = host
+}
+
+// fail records a failure of source and reports a recoverable error unless
+// source was already failing.
+func (h *healthReporter) fail(source string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.permanent {
+		return
+	}
+	_, failing := h.failures[source]
+	h.failures[source] = fmt.Errorf("%s: %w", source, err)
+	if !failing {
+		componentstatus.ReportStatus(h.host, componentstatus.NewRecoverableErrorEvent(h.errLocked()))
+	}
+}
+
+// recover clears a failure of source and reports OK once nothing is failing.
+func (h *healthReporter) recover(source string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.failures[source]; !ok || h.permanent {
+		return
+	}
+	delete(h.failures, source)
+	if len(h.failures) == 0 {
+		componentstatus.ReportStatus(h.host, componentstatus.NewEvent(componentstatus.StatusOK))
+		return
+	}
+	componentstatus.ReportStatus(h.host, componentstatus.NewRecoverableErrorEvent(h.errLocked()))
+}
+
+// corrupt reports a permanent error.
+func (h *healthReporter) corrupt(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.permanent = true
+	componentstatus.ReportStatus(h.host, componentstatus.NewPermanentErrorEvent(err))
+}
+
+// errLocked joins the current failures in a stable order.
+func (h *healthReporter) errLocked() error {
+	sources := make([]string, 0, len(h.failures))
+	for source := range h.failures {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	errs := make([]error, 0, len(sources))
+	for _, source := range sources {
+		errs = append(errs, h.failures[source])
+	}
+	return errors.Join(errs...)
+}
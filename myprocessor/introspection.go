@@ -0,0 +1,334 @@
+package simpleprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/confighttp"
+	"go.opentelemetry.io/collector/config/configopaque"
+	"go.uber.org/zap"
+)
+
+const defaultIntrospectionReadHeaderTimeout = 10 * time.Second
+
+// IntrospectionConfig configures the HTTP endpoint that exposes the live
+// aggregation state.
+type IntrospectionConfig struct {
+	// ServerConfig is the address to listen on, e.g. localhost:55690, and
+	// its TLS, auth and CORS settings.
+	confighttp.ServerConfig `mapstructure:",squash"`
+
+	// AdminToken enables the admin API. Requests to it must send the token
+	// as a bearer token.
+	AdminToken configopaque.String `mapstructure:"admin_token"`
+
+	// TenantAuthAttribute is the attribute of the authenticated client that
+	// names its tenant, e.g. a claim of the auth extension. Requests are
+	// scoped to that tenant and rejected when the client has none. Without
+	// it, with tenancy on, callers see every tenant.
+	TenantAuthAttribute string `mapstructure:"tenant_auth_attribute"`
+}
+
+// Validate checks the configuration.
+func (c *IntrospectionConfig) Validate() error {
+	if c.Endpoint == "" {
+		return errors.New("endpoint must be set")
+	}
+	if c.TenantAuthAttribute != "" && !c.Auth.HasValue() {
+		return errors.New("tenant_auth_attribute needs auth")
+	}
+	return nil
+}
+
+// opResult is the outcome of the latest run of a background operation.
+type opResult struct {
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration_seconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func newOpResult(start time.Time, d time.Duration, err error) opResult {
+	r := opResult{Time: start, Duration: d.Seconds()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+type flushResult struct {
+	opResult
+	DataPoints int `json:"data_points"`
+}
+
+type checkpointResult struct {
+	opResult
+	Size int `json:"size_bytes"`
+}
+
+// lastResults keeps the latest flush and checkpoint outcomes for the
+// introspection endpoint.
+type lastResults struct {
+	mu          sync.Mutex
+	flush       *flushResult
+	checkpoints map[string]*checkpointResult // by store
+}
+
+func (r *lastResults) recordFlush(start time.Time, d time.Duration, dataPoints int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flush = &flushResult{opResult: newOpResult(start, d, err), DataPoints: dataPoints}
+}
+
+func (r *lastResults) recordCheckpoint(store stateStore, start time.Time, d time.Duration, size int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.checkpoints == nil {
+		r.checkpoints = make(map[string]*checkpointResult)
+	}
+	r.checkpoints[store.String()] = &checkpointResult{opResult: newOpResult(start, d, err), Size: size}
+}
+
+type introspectionServer struct {
+	server *http.Server
+}
+
+// startIntrospection starts serving the introspection endpoint.
+func (p *simpleProcessor) startIntrospection(ctx context.Context, host component.Host) error {
+	cfg := p.cfg.Introspection.ServerConfig
+	if cfg.ReadHeaderTimeout == 0 {
+		cfg.ReadHeaderTimeout = defaultIntrospectionReadHeaderTimeout
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rules", p.handleRules)
+	mux.HandleFunc("GET /series", p.handleSeries)
+	mux.HandleFunc("GET /status", p.handleStatus)
+	if p.cfg.Introspection.AdminToken != "" {
+		p.registerAdmin(mux)
+	}
+
+	server, err := cfg.ToServer(ctx, host, p.settings, mux)
+	if err != nil {
+		return fmt.Errorf("failed to create introspection server: %w", err)
+	}
+	ln, err := cfg.ToListener(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", cfg.Endpoint, err)
+	}
+
+	p.server = &introspectionServer{server: server}
+	go func() {
+		if err := p.server.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.logger.Error("Introspection server failed", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (s *introspectionServer) shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// errNoTenant rejects authenticated callers that don't name a tenant.
+var errNoTenant = errors.New("the client has no tenant")
+
+// scopeTenant narrows a request for tenant, where empty means every tenant,
+// down to the tenant of the caller. It returns tenant unchanged unless
+// tenant_auth_attribute is set, and fails when the caller asks for another
+// tenant or has none.
+func (p *simpleProcessor) scopeTenant(ctx context.Context, tenant string) (string, error) {
+	attr := p.cfg.Introspection.TenantAuthAttribute
+	if attr == "" {
+		return tenant, nil
+	}
+	auth := client.FromContext(ctx).Auth
+	if auth == nil {
+		return "", errNoTenant
+	}
+	caller, _ := auth.GetAttribute(attr).(string)
+	if caller == "" {
+		return "", errNoTenant
+	}
+	if tenant != "" && tenant != caller {
+		return "", fmt.Errorf("tenant %q is not accessible to the client", tenant)
+	}
+	return caller, nil
+}
+
+type ruleSummary struct {
+	Tenant string `json:"tenant,omitempty"`
+	Name   string `json:"name"`
+	Series int    `json:"series"`
+}
+
+// handleRules lists the rules and how many series each holds, per tenant.
+// tenant=<name> narrows it down to one tenant.
+func (p *simpleProcessor) handleRules(w http.ResponseWriter, r *http.Request) {
+	tenantName, err := p.scopeTenant(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	}
+
+	p.mu.Lock()
+	rules := []ruleSummary{}
+	for tenant, t := range p.tenants {
+		if tenantName != "" && tenant != tenantName {
+			continue
+		}
+		for name, state := range t.rules {
+			rules = append(rules, ruleSummary{Tenant: tenant, Name: name, Series: len(state.Series)})
+		}
+	}
+	p.mu.Unlock()
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Tenant != rules[j].Tenant {
+			return rules[i].Tenant < rules[j].Tenant
+		}
+		return rules[i].Name < rules[j].Name
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"rules": rules})
+}
+
+type seriesView struct {
+	Key string `json:"key"`
+	series
+}
+
+type ruleView struct {
+	Tenant string       `json:"tenant,omitempty"`
+	Name   string       `json:"name"`
+	Series []seriesView `json:"series"`
+}
+
+// handleSeries lists series with their values. The query can narrow it down:
+//
+//	tenant=<name>       only series of this tenant
+//	rule=<name>         only series of this rule
+//	match=<key>=<value> only series with this attribute value, repeatable
+//	limit=<n>           at most n series per rule
+func (p *simpleProcessor) handleSeries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenantName, err := p.scopeTenant(r.Context(), query.Get("tenant"))
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	}
+	ruleName := query.Get("rule")
+	matchers, err := parseMatchers(query["match"])
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a non-negative integer"})
+			return
+		}
+	}
+
+	p.mu.Lock()
+	rules := []ruleView{}
+	for tenant, t := range p.tenants {
+		if tenantName != "" && tenant != tenantName {
+			continue
+		}
+		for name, state := range t.rules {
+			if ruleName != "" && name != ruleName {
+				continue
+			}
+			rules = append(rules, seriesOf(tenant, name, state, matchers))
+		}
+	}
+	p.mu.Unlock()
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Tenant != rules[j].Tenant {
+			return rules[i].Tenant < rules[j].Tenant
+		}
+		return rules[i].Name < rules[j].Name
+	})
+	for i := range rules {
+		sort.Slice(rules[i].Series, func(a, b int) bool { return rules[i].Series[a].Key < rules[i].Series[b].Key })
+		if limit > 0 && len(rules[i].Series) > limit {
+			rules[i].Series = rules[i].Series[:limit]
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rules": rules})
+}
+
+// seriesOf returns a copy of the series of a rule that have the matchers'
+// attributes.
+func seriesOf(tenant, name string, state *ruleState, matchers map[string]string) ruleView {
+	view := ruleView{Tenant: tenant, Name: name, Series: []seriesView{}}
+	for key, s := range state.Series {
+		if !s.matches(matchers) {
+			continue
+		}
+		// Sketches are shared with ConsumeMetrics and are left out, the
+		// value carries their estimate or count.
+		cp := *s
+		cp.aggregate = aggregate{Value: s.Value}
+		cp.Unique = nil
+		if s.Panes != nil {
+			cp.Panes = make(map[int64]*pane, len(s.Panes))
+			for start, pn := range s.Panes {
+				cp.Panes[start] = &pane{aggregate: aggregate{Value: pn.Value}}
+			}
+		}
+		cp.Attributes = make(map[string]string, len(s.Attributes))
+		for k, v := range s.Attributes {
+			cp.Attributes[k] = v
+		}
+		view.Series = append(view.Series, seriesView{Key: key, series: cp})
+	}
+	return view
+}
+
+// handleStatus shows the latest flush and checkpoint outcomes.
+func (p *simpleProcessor) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	p.mu.Lock()
+	degraded, corrupt := p.degraded, p.corrupt
+	p.mu.Unlock()
+
+	p.status.mu.Lock()
+	body := map[string]any{
+		"last_flush":       p.status.flush,
+		"last_checkpoints": p.status.checkpoints,
+		"degraded":         degraded,
+		"corrupt":          corrupt,
+	}
+	writeJSON(w, http.StatusOK, body)
+	p.status.mu.Unlock()
+}
+
+// parseMatchers parses key=value attribute matchers.
+func parseMatchers(raw []string) (map[string]string, error) {
+	matchers := make(map[string]string, len(raw))
+	for _, m := range raw {
+		k, v, ok := strings.Cut(m, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid match %q, expected key=value", m)
+		}
+		matchers[k] = v
+	}
+	return matchers, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,243 @@
+package simpleprocessor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Values a log record contributes to a logs rule.
+const (
+	// LogValueCount counts log records.
+	LogValueCount = "count"
+	// LogValueBody is the numeric body of the log record.
+	LogValueBody = "body"
+	// LogValueAttribute is a numeric attribute of the log record, or a named
+	// group of the body pattern.
+	LogValueAttribute = "attribute"
+)
+
+// severityAttribute carries the severity of a log record, so rules can group
+// by it.
+const severityAttribute = "severity"
+
+// LogConfig configures which log records a logs rule aggregates and what
+// each contributes.
+type LogConfig struct {
+	// BodyPattern restricts the rule to log records whose body matches this
+	// regular expression. Its named groups become attributes, e.g.
+	// 'work finished type=(?P<type>\w+)'.
+	BodyPattern string `mapstructure:"body_pattern"`
+
+	// Value is count (default), body or attribute.
+	Value string `mapstructure:"value"`
+
+	// Attribute is the attribute or named group holding the number with the
+	// attribute value.
+	Attribute string `mapstructure:"attribute"`
+}
+
+// Validate checks the configuration.
+func (c *LogConfig) Validate() error {
+	if _, err := regexp.Compile(c.BodyPattern); err != nil {
+		return fmt.Errorf("body_pattern: %w", err)
+	}
+	switch c.Value {
+	case "", LogValueCount, LogValueBody:
+		if c.Attribute != "" {
+			return errors.New("attribute only applies to the attribute value")
+		}
+	case LogValueAttribute:
+		if c.Attribute == "" {
+			return errors.New("attribute must be set for the attribute value")
+		}
+	default:
+		return errors.New("value must be count, body or attribute")
+	}
+	return nil
+}
+
+func (c *LogConfig) value() string {
+	if c.Value == "" {
+		return LogValueCount
+	}
+	return c.Value
+}
+
+// matchLog reports whether the rule aggregates record, and returns the named
+// groups of the body pattern if it has one.
+func (r *rule) matchLog(record plog.LogRecord) (groups []string, ok bool) {
+	if r.source() != SourceLogs {
+		return nil, false
+	}
+	if r.bodyPattern == nil {
+		return nil, true
+	}
+	groups = r.bodyPattern.FindStringSubmatch(record.Body().AsString())
+	return groups, groups != nil
+}
+
+// prepareLog fills dp, which holds the attributes of record, for the rule:
+// the named groups of the body pattern and the value. It returns the reason
+// record is dropped, or an empty string.
+func (r *rule) prepareLog(dp pmetric.NumberDataPoint, record plog.LogRecord, groups []string) string {
+	if r.bodyPattern != nil {
+		for i, name := range r.bodyPattern.SubexpNames() {
+			if name != "" && i < len(groups) {
+				dp.Attributes().PutStr(name, groups[i])
+			}
+		}
+	}
+
+	var v pcommon.Value
+	switch r.Log.value() {
+	case LogValueBody:
+		v = record.Body()
+	case LogValueAttribute:
+		var ok bool
+		if v, ok = dp.Attributes().Get(r.Log.Attribute); !ok {
+			return dropMissingGroupKey
+		}
+	default:
+		dp.SetIntValue(1)
+		return ""
+	}
+	n, ok := numericValue(v)
+	if !ok {
+		return dropInvalidValue
+	}
+	dp.SetDoubleValue(n)
+	return ""
+}
+
+// logDataPoint fills dp with the attributes, severity and timestamp of
+// record.
+func logDataPoint(dp pmetric.NumberDataPoint, record plog.LogRecord) {
+	attrs := dp.Attributes()
+	attrs.Clear()
+	record.Attributes().CopyTo(attrs)
+	if _, ok := attrs.Get(severityAttribute); !ok {
+		attrs.PutStr(severityAttribute, severity(record))
+	}
+	ts := record.Timestamp()
+	if ts == 0 {
+		ts = record.ObservedTimestamp()
+	}
+	dp.SetTimestamp(ts)
+}
+
+// severity returns the severity text of record, or the name of its severity
+// number if it has no text, e.g. info or error2.
+func severity(record plog.LogRecord) string {
+	if text := record.SeverityText(); text != "" {
+		return strings.ToLower(text)
+	}
+	if record.SeverityNumber() == plog.SeverityNumberUnspecified {
+		return "unspecified"
+	}
+	return strings.ToLower(record.SeverityNumber().String())
+}
+
+// numericValue returns v as a number, parsing strings.
+func numericValue(v pcommon.Value) (float64, bool) {
+	switch v.Type() {
+	case pcommon.ValueTypeInt:
+		return float64(v.Int()), true
+	case pcommon.ValueTypeDouble:
+		return v.Double(), true
+	case pcommon.ValueTypeStr:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v.Str()), 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// logHash identifies a log record by its resource, timestamps, severity,
+// body and attributes.
+func logHash(resource pcommon.Resource, record plog.LogRecord) uint64 {
+	h := fnv.New64a()
+	hashMap(h, resource.Attributes())
+	hashMap(h, record.Attributes())
+	h.Write([]byte(record.Body().AsString()))
+	h.Write([]byte{0})
+	var buf [8]byte
+	for _, v := range []uint64{
+		uint64(record.Timestamp()),
+		uint64(record.ObservedTimestamp()),
+		uint64(record.SeverityNumber()),
+	} {
+		binary.BigEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// consumeLogs aggregates the log records of ld with the logs rules.
+func (p *simpleProcessor) consumeLogs(ctx context.Context, ld plog.Logs) {
+	if !slices.ContainsFunc(p.rules, func(r *rule) bool { return r.source() == SourceLogs }) {
+		return
+	}
+	counts := newConsumeCounts()
+	defer counts.record(ctx, p.telemetry)
+	defer p.sendQuarantine(ctx, counts)
+
+	p.lock(ctx, opConsume)
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	info := client.FromContext(ctx)
+	dp := pmetric.NewNumberDataPoint()
+	var matched []*rule
+	var groups [][]string
+	for i := 0; i < ld.ResourceLogs().Len(); i++ {
+		rl := ld.ResourceLogs().At(i)
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			records := rl.ScopeLogs().At(j).LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				record := records.At(k)
+				matched, groups = matched[:0], groups[:0]
+				for _, r := range p.rules {
+					if g, ok := r.matchLog(record); ok {
+						matched = append(matched, r)
+						groups = append(groups, g)
+					}
+				}
+				if len(matched) == 0 {
+					continue
+				}
+				logDataPoint(dp, record)
+				hash := func() uint64 { return logHash(rl.Resource(), record) }
+				// The named groups of a body pattern are only seen by their
+				// rule and can be joined on, the attributes are filled in
+				// again after them.
+				refill := false
+				prepare := func(i int) string {
+					if refill {
+						logDataPoint(dp, record)
+						p.enrich(dp.Attributes())
+					}
+					refill = matched[i].bodyPattern != nil
+					reason := matched[i].prepareLog(dp, record, groups[i])
+					if refill {
+						p.enrich(dp.Attributes())
+					}
+					return reason
+				}
+				p.aggregateLocked(counts, info, rl.Resource(), dp, hash, matched, prepare, now)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package simpleprocessor
+
+import (
+	"strconv"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// outputScope is the instrumentation scope of flushed metrics and alerts.
+const outputScope = "simple-aggregator"
+
+// collectorIdentityPrefix prefixes the collector's own resource attributes so
+// they don't clash with those kept from the aggregated data.
+const collectorIdentityPrefix = "collector."
+
+// output collects the metrics and alert records of a flush, one resource per
+// distinct tenant and resource.
+type output struct {
+	md              pmetric.Metrics
+	ld              plog.Logs
+	identity        map[string]string
+	tenantAttribute string // empty without tenancy
+	scopes          map[string]pmetric.ScopeMetrics
+	logScopes       map[string]plog.ScopeLogs
+
+	// origins are the tenant and resource of every ResourceMetrics, by
+	// index.
+	origins []outputOrigin
+}
+
+type outputOrigin struct {
+	tenant   string
+	resource map[string]string
+}
+
+func newOutput(identity map[string]string, tenancy *TenancyConfig) *output {
+	o := &output{
+		md:        pmetric.NewMetrics(),
+		ld:        plog.NewLogs(),
+		identity:  identity,
+		scopes:    make(map[string]pmetric.ScopeMetrics),
+		logScopes: make(map[string]plog.ScopeLogs),
+	}
+	if tenancy != nil {
+		o.tenantAttribute = tenancy.outputAttribute()
+	}
+	return o
+}
+
+// scope returns the scope metrics of a tenant's resource, creating them on
+// first use.
+func (o *output) scope(tenant string, resource map[string]string) pmetric.ScopeMetrics {
+	key := strconv.Quote(tenant) + seriesKey(resource)
+	if sm, ok := o.scopes[key]; ok {
+		return sm
+	}
+	rm := o.md.ResourceMetrics().AppendEmpty()
+	o.putResource(rm.Resource(), tenant, resource)
+	o.origins = append(o.origins, outputOrigin{tenant: tenant, resource: resource})
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName(outputScope)
+	o.scopes[key] = sm
+	return sm
+}
+
+// logScope returns the scope logs of a tenant's resource, creating them on
+// first use.
+func (o *output) logScope(tenant string, resource map[string]string) plog.ScopeLogs {
+	key := strconv.Quote(tenant) + seriesKey(resource)
+	if sl, ok := o.logScopes[key]; ok {
+		return sl
+	}
+	rl := o.ld.ResourceLogs().AppendEmpty()
+	o.putResource(rl.Resource(), tenant, resource)
+	sl := rl.ScopeLogs().AppendEmpty()
+	sl.Scope().SetName(outputScope)
+	o.logScopes[key] = sl
+	return sl
+}
+
+// putResource sets the attributes of a tenant's resource.
+func (o *output) putResource(res pcommon.Resource, tenant string, resource map[string]string) {
+	putAttributes(res.Attributes(), o.identity)
+	putAttributes(res.Attributes(), resource)
+	if o.tenantAttribute != "" && tenant != "" {
+		res.Attributes().PutStr(o.tenantAttribute, tenant)
+	}
+}
+
+// collectorIdentity returns the collector's resource attributes, prefixed.
+func collectorIdentity(resource pcommon.Resource) map[string]string {
+	identity := make(map[string]string, resource.Attributes().Len())
+	for k, v := range resource.Attributes().All() {
+		identity[collectorIdentityPrefix+k] = v.AsString()
+	}
+	return identity
+}
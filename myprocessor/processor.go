@@ -2,65 +2,164 @@ package simpleprocessor
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/collector/client"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/extension/xextension/storage"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor"
 	"go.uber.org/zap"
 )
 
+// checkpointKey is the key the aggregation state is stored under. With
+// tenancy every tenant has its own key, see tenantKey.
+const checkpointKey = "aggregations"
+
+// errCorruptState is returned instead of overwriting a checkpoint that
+// couldn't be decoded.
+var errCorruptState = errors.New("checkpointing is disabled because the stored state is corrupt")
+
+// defaultRule names the work.type aggregation used when no rules are
+// configured, and the rule a version 1 checkpoint is migrated to.
+const defaultRule = "work_done_batched"
+
 type simpleProcessor struct {
-	logger *zap.Logger
-	next   consumer.Metrics
+	logger   *zap.Logger
+	settings component.TelemetrySettings // for the introspection server
+	next     consumer.Metrics
+	cfg      *Config
+
+	// logsOut receives the records of alerts that start or stop firing and
+	// of quarantined data points, nil when nothing does.
+	logsOut consumer.Logs
 
-	mu             sync.Mutex
-	aggregations   map[string]int64 // Aggregates sums by work.type
-	done           chan struct{}
-	checkpointFile string
+	mu      sync.Mutex
+	rules   []*rule
+	alerts  []*alert
+	derived []*derived
 
-	storageID     *component.ID
-	storageClient storage.Client
-	id            component.ID
+	recording []*recordingRule
+	tenants   map[string]*tenantState
+	evicted   []string // tenants whose checkpoints are left to delete
+	done      chan struct{}
+
+	// enrichments swap their tables on reload, they aren't guarded by mu.
+	enrichments []*enrichment
+
+	// primary is where the checkpoint normally lives. secondary is only set
+	// with the fallback policy and is written alongside the primary, so it is
+	// current whenever the primary goes away.
+	primary   stateStore
+	secondary stateStore
+	degraded  bool // primary is unavailable or unread, only the secondary is written
+	loaded    bool // the stored state was read, so checkpoints may replace it
+	corrupt   bool // the stored state couldn't be decoded, stop aggregating and overwriting it
+	health    *healthReporter
+	telemetry *telemetry
+	status    *lastResults
+	server    *introspectionServer
+	id        component.ID
+	kind      component.Kind    // processor or connector, namespaces the storage client
+	identity  map[string]string // stamped on flushed resources, nil unless collector_identity is set
 }
 
-func newProcessor(logger *zap.Logger, next consumer.Metrics, checkpointFile string, storageID *component.ID, id component.ID) *simpleProcessor {
-	return &simpleProcessor{
-		logger:         logger,
-		next:           next,
-		aggregations:   make(map[string]int64),
-		done:           make(chan struct{}),
-		checkpointFile: checkpointFile,
-		storageID:      storageID,
-		id:             id,
+func newProcessor(set processor.Settings, next consumer.Metrics, cfg *Config) (*simpleProcessor, error) {
+	tel, err := newTelemetry(set.TelemetrySettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create telemetry: %w", err)
 	}
+	rules := newRules(cfg.Rules)
+	p := &simpleProcessor{
+		logger:      set.Logger,
+		settings:    set.TelemetrySettings,
+		next:        next,
+		cfg:         cfg,
+		rules:       rules,
+		alerts:      newAlerts(cfg.Alerts, rules),
+		derived:     newDerived(cfg.Derived),
+		recording:   newRecordingRules(cfg.Recording),
+		enrichments: newEnrichments(cfg.Enrichment),
+		tenants:     make(map[string]*tenantState),
+		done:        make(chan struct{}),
+		health:      newHealthReporter(),
+		telemetry:   tel,
+		status:      &lastResults{},
+		id:          set.ID,
+		kind:        component.KindProcessor,
+	}
+	if cfg.CollectorIdentity {
+		p.identity = collectorIdentity(set.Resource)
+	}
+	if err := tel.observeActiveSeries(p.activeSeries); err != nil {
+		return nil, fmt.Errorf("failed to register telemetry callback: %w", err)
+	}
+	return p, nil
 }
 
-func (p *simpleProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+// lock takes the state lock and records how long it took to get it.
+func (p *simpleProcessor) lock(ctx context.Context, op string) {
+	start := time.Now()
+	p.mu.Lock()
+	p.telemetry.recordLockWait(ctx, op, time.Since(start))
+}
+
+// activeSeries returns the number of series held per tenant and rule.
+func (p *simpleProcessor) activeSeries() map[countKey]int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	counts := make(map[countKey]int)
+	for tenant, t := range p.tenants {
+		for name, state := range t.rules {
+			counts[countKey{tenant: tenant, rule: name}] = len(state.Series)
+		}
+	}
+	return counts
+}
+
+func (p *simpleProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	counts := newConsumeCounts()
+	defer counts.record(ctx, p.telemetry)
+	defer p.sendQuarantine(ctx, counts)
+
+	p.lock(ctx, opConsume)
+	defer p.mu.Unlock()
 
+	now := time.Now()
+	info := client.FromContext(ctx)
 	for i := 0; i < md.ResourceMetrics().Len(); i++ {
 		rm := md.ResourceMetrics().At(i)
 		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
 			sm := rm.ScopeMetrics().At(j)
 			for k := 0; k < sm.Metrics().Len(); k++ {
 				metric := sm.Metrics().At(k)
-				if metric.Type() == pmetric.MetricTypeSum {
-					sum := metric.Sum()
-					for l := 0; l < sum.DataPoints().Len(); l++ {
-						dp := sum.DataPoints().At(l)
-						// For demo: Aggregate by 'work.type', ignoring unique 'work.id'
-						if workType, ok := dp.Attributes().Get("work.type"); ok {
-							p.aggregations[workType.Str()] += dp.IntValue()
-						}
+				var matched []*rule
+				for _, r := range p.rules {
+					if r.matches(metric) {
+						matched = append(matched, r)
 					}
 				}
+				if len(matched) == 0 {
+					counts.dropped[countKey{reason: dropNoMatchingRule}] += int64(dataPointCount(metric))
+					continue
+				}
+				units := newUnitConversion(matched, metric.Unit())
+				dps := numberDataPoints(metric)
+				for l := 0; l < dps.Len(); l++ {
+					dp := dps.At(l)
+					hash := func() uint64 { return dataPointHash(rm.Resource(), metric, dp) }
+					var prepare func(i int) string
+					if units != nil {
+						prepare = units.prepare(dp)
+					}
+					p.aggregateLocked(counts, info, rm.Resource(), dp, hash, matched, prepare, now)
+				}
 			}
 		}
 	}
@@ -68,106 +167,549 @@ func (p *simpleProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Metrics
 	return nil
 }
 
+// consumeCounts counts what happened to the data points of one request.
+type consumeCounts struct {
+	aggregated map[countKey]int64
+	dropped    map[countKey]int64
+	late       map[countKey]int64
+
+	// quarantine collects the data points rejected as invalid, nil until
+	// there is one to send to the logs output.
+	quarantine *output
+}
+
+func newConsumeCounts() *consumeCounts {
+	return &consumeCounts{
+		aggregated: make(map[countKey]int64),
+		dropped:    make(map[countKey]int64),
+		late:       make(map[countKey]int64),
+	}
+}
+
+func (c *consumeCounts) record(ctx context.Context, t *telemetry) {
+	for k, n := range c.aggregated {
+		t.recordAggregated(ctx, k.tenant, k.rule, n)
+	}
+	for k, n := range c.dropped {
+		t.recordDropped(ctx, k.tenant, k.rule, k.reason, n)
+	}
+	for k, n := range c.late {
+		t.recordLate(ctx, k.tenant, k.rule, k.reason, n)
+	}
+}
+
+// aggregateLocked adds a data point to the matched rules of its tenant. hash
+// identifies the data point for deduplication and is only called when that
+// is on; it is remembered once a rule aggregated the data point. The lookup
+// tables are joined in once, so their attributes can be grouped by. prepare,
+// if set, is called with the index of each matched rule to fill in the data
+// point for it, joining the tables again if it changes the attributes; it
+// returns the reason the data point is dropped by that rule or an empty
+// string. The data point is then validated, rejected ones going to the
+// quarantine.
+func (p *simpleProcessor) aggregateLocked(
+	counts *consumeCounts,
+	info client.Info,
+	resource pcommon.Resource,
+	dp pmetric.NumberDataPoint,
+	hash func() uint64,
+	matched []*rule,
+	prepare func(i int) string,
+	now time.Time,
+) {
+	if p.corrupt {
+		counts.dropped[countKey{reason: dropCorruptState}]++
+		return
+	}
+	tenancy := p.cfg.Tenancy
+	tenant := ""
+	if tenancy != nil {
+		var ok bool
+		if tenant, ok = tenancy.tenant(info, resource, dp); !ok {
+			counts.dropped[countKey{reason: dropMissingTenant}]++
+			return
+		}
+		if tenant, ok = p.admitTenantLocked(tenant); !ok {
+			counts.dropped[countKey{reason: dropTenantLimit}]++
+			return
+		}
+	}
+	t := p.tenantLocked(tenant)
+	t.lastSeen = now
+	var h uint64
+	if t.dedup != nil {
+		if h = hash(); t.dedup.contains(h, now) {
+			counts.dropped[countKey{tenant: tenant, reason: dropDuplicate}]++
+			return
+		}
+	}
+	p.enrich(dp.Attributes())
+	accepted := false
+	for i, r := range matched {
+		if prepare != nil {
+			if reason := prepare(i); reason != "" {
+				counts.dropped[countKey{tenant, r.Name, reason}]++
+				continue
+			}
+		}
+		if reason := r.validate(dp); reason != "" {
+			counts.dropped[countKey{tenant, r.Name, reason}]++
+			p.quarantineLocked(counts, tenant, resource, r, dp, reason, now)
+			continue
+		}
+		state := t.ruleStateLocked(r.Name)
+		// Grouping drops every other attribute, e.g. the unique 'work.id'
+		attrs, ok := r.groupAttributes(dp.Attributes())
+		if !ok {
+			counts.dropped[countKey{tenant, r.Name, dropMissingGroupKey}]++
+			continue
+		}
+		res := r.resourceAttributes(resource.Attributes())
+		key := resourceSeriesKey(res, attrs)
+		s, ok := state.Series[key]
+		if !ok {
+			if tenancy != nil && tenancy.MaxSeries > 0 && t.seriesCount() >= tenancy.MaxSeries {
+				counts.dropped[countKey{tenant, r.Name, dropSeriesLimit}]++
+				continue
+			}
+			s = &series{Attributes: attrs, Resource: res, StartTime: now}
+		}
+		handling, reason := r.add(state, s, dp, now)
+		if handling != "" {
+			counts.late[countKey{tenant, r.Name, handling}]++
+		}
+		if reason != "" {
+			counts.dropped[countKey{tenant, r.Name, reason}]++
+			continue
+		}
+		state.Series[key] = s
+		s.LastSeen = now
+		counts.aggregated[countKey{tenant: tenant, rule: r.Name}]++
+		accepted = true
+	}
+	if accepted && t.dedup != nil {
+		t.dedup.add(h, now)
+	}
+}
+
+// tenantLocked returns the state of a tenant, creating it on first use.
+func (p *simpleProcessor) tenantLocked(name string) *tenantState {
+	t, ok := p.tenants[name]
+	if !ok {
+		t = p.newTenant(make(map[string]*ruleState), nil)
+		p.tenants[name] = t
+	}
+	return t
+}
+
+// newTenant creates the state of a tenant from its rules and, when
+// deduplication is on, the data points it has seen.
+func (p *simpleProcessor) newTenant(rules map[string]*ruleState, dedup *dedupSet) *tenantState {
+	t := &tenantState{rules: rules, lastSeen: time.Now()}
+	if p.cfg.Deduplication != nil {
+		if dedup == nil {
+			dedup = newDedupSet()
+		}
+		t.dedup = dedup
+		t.dedup.configure(p.cfg.Deduplication)
+	}
+	return t
+}
+
+// ruleStateLocked returns the state of a rule, creating it on first use.
+func (t *tenantState) ruleStateLocked(name string) *ruleState {
+	state, ok := t.rules[name]
+	if !ok {
+		state = newRuleState()
+		t.rules[name] = state
+	}
+	return state
+}
+
 func (p *simpleProcessor) Capabilities() consumer.Capabilities {
 	return consumer.Capabilities{MutatesData: true}
 }
 
 func (p *simpleProcessor) Start(ctx context.Context, host component.Host) error {
-	if p.storageID != nil {
-		ext, ok := host.GetExtensions()[*p.storageID]
+	p.health.setHost(host)
+	if p.cfg.StorageID != nil {
+		ext, ok := host.GetExtensions()[*p.cfg.StorageID]
 		if !ok {
-			return fmt.Errorf("storage extension %q not found", p.storageID)
+			return fmt.Errorf("storage extension %q not found", p.cfg.StorageID)
 		}
 		storageExt, ok := ext.(storage.Extension)
 		if !ok {
-			return fmt.Errorf("extension %q is not a storage extension", p.storageID)
+			return fmt.Errorf("extension %q is not a storage extension", p.cfg.StorageID)
 		}
-		client, err := storageExt.GetClient(ctx, component.KindProcessor, p.id, "")
+		client, err := storageExt.GetClient(ctx, p.kind, p.id, "")
 		if err != nil {
 			return fmt.Errorf("failed to get storage client: %w", err)
 		}
-		p.storageClient = client
+		p.primary = &extensionStore{client: client, name: p.cfg.StorageID.String()}
+
+		if p.cfg.CheckpointFile != "" {
+			if p.cfg.StorageFailure.Policy == StorageFailureFallback {
+				p.secondary = &fileStore{path: p.cfg.CheckpointFile}
+			} else {
+				p.logger.Warn("checkpoint_file is ignored because storage is configured, use the fallback storage_failure policy to keep it as a secondary store")
+			}
+		}
+	} else if p.cfg.CheckpointFile != "" {
+		p.primary = &fileStore{path: p.cfg.CheckpointFile}
 	}
 
-	p.loadState(ctx)
+	if err := p.loadState(ctx); err != nil {
+		if p.cfg.StorageFailure.policy() != StorageFailureContinue {
+			return err
+		}
+		// The unread state is merged in once the primary can be read,
+		// until then it is not overwritten.
+		p.logger.Error("Failed to load state, starting from empty state", zap.Error(err))
+		p.health.fail(healthLoad, err)
+		p.mu.Lock()
+		p.degraded = true
+		p.mu.Unlock()
+	}
+	if err := p.startEnrichment(); err != nil {
+		return err
+	}
+	if p.cfg.Introspection != nil {
+		if err := p.startIntrospection(ctx, host); err != nil {
+			return err
+		}
+	}
 	go p.flushLoop()
 	return nil
 }
 
 func (p *simpleProcessor) Shutdown(ctx context.Context) error {
 	close(p.done)
-	p.saveState(ctx)
-	if p.storageClient != nil {
-		return p.storageClient.Close(ctx)
+	// State that was never loaded would overwrite the stored state.
+	if p.isLoaded() {
+		p.saveState(ctx)
 	}
-	return nil
+
+	errs := []error{p.telemetry.shutdown()}
+	if p.server != nil {
+		errs = append(errs, p.server.shutdown(ctx))
+	}
+	for _, s := range []stateStore{p.primary, p.secondary} {
+		if s != nil {
+			errs = append(errs, s.close(ctx))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (p *simpleProcessor) loadState(ctx context.Context) {
+// loadState restores the aggregations from the primary store, applying the
+// storage failure policy when it can't be read.
+func (p *simpleProcessor) loadState(ctx context.Context) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	var data []byte
-	var err error
+	if p.primary == nil {
+		return nil
+	}
 
-	if p.storageClient != nil {
-		data, err = p.storageClient.Get(ctx, "aggregations")
+	data, err := p.loadPrimary(ctx)
+	var docs map[string][]byte
+	if err == nil {
+		docs, err = loadTenantCheckpoints(ctx, p.primary, data)
+	}
+	if err != nil {
+		if p.secondary == nil {
+			return fmt.Errorf("failed to read checkpoint from %s: %w", p.primary, err)
+		}
+		p.logger.Warn("Failed to read checkpoint, falling back to secondary store",
+			zap.Stringer("primary", p.primary), zap.Stringer("secondary", p.secondary), zap.Error(err))
+		p.degraded = true
+		p.health.fail(healthDegraded, err)
+		data, err = p.secondary.load(ctx, checkpointKey)
+		if err == nil {
+			docs, err = loadTenantCheckpoints(ctx, p.secondary, data)
+		}
 		if err != nil {
-			p.logger.Error("Failed to read checkpoint from storage", zap.Error(err))
-			return
+			return fmt.Errorf("failed to read checkpoint from %s: %w", p.secondary, err)
 		}
-		if data == nil {
-			// Not found
-			return
+	}
+	p.loaded = true
+	if docs == nil {
+		// Not found
+		return nil
+	}
+
+	tenants, err := p.decodeTenants(docs)
+	if err != nil {
+		// Keep the stored state untouched so it can be inspected and repaired.
+		p.logger.Error("Failed to unmarshal checkpoint, aggregation is stopped until it is repaired", zap.Error(err))
+		p.corrupt = true
+		p.health.corrupt(fmt.Errorf("corrupt checkpoint: %w", err))
+		return nil
+	}
+	p.tenants = tenants
+	return nil
+}
+
+// loadTenantCheckpoints reads the checkpoints of the tenants listed in the
+// main checkpoint from store. It returns the encoded checkpoints by tenant,
+// or nil if there is no main checkpoint.
+func loadTenantCheckpoints(ctx context.Context, store stateStore, main []byte) (map[string][]byte, error) {
+	if main == nil {
+		return nil, nil
+	}
+	docs := map[string][]byte{"": main}
+	// A main checkpoint that can't be decoded lists no tenants, it fails in
+	// decodeTenants.
+	for _, tenant := range checkpointTenants(main) {
+		data, err := store.load(ctx, tenantKey(tenant))
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tenant, err)
+		}
+		if data != nil {
+			docs[tenant] = data
 		}
-	} else if p.checkpointFile != "" {
-		data, err = os.ReadFile(p.checkpointFile)
+	}
+	return docs, nil
+}
+
+// decodeTenants decodes the checkpoint of every tenant.
+func (p *simpleProcessor) decodeTenants(docs map[string][]byte) (map[string]*tenantState, error) {
+	now := time.Now()
+	rates := make(map[string]struct{})
+	for _, d := range p.derived {
+		for _, id := range exprRates(d.expr) {
+			rates[id] = struct{}{}
+		}
+	}
+	tenants := make(map[string]*tenantState, len(docs))
+	for tenant, data := range docs {
+		cp, err := decodeCheckpoint(data, now)
 		if err != nil {
-			if !os.IsNotExist(err) {
-				p.logger.Error("Failed to read checkpoint file", zap.Error(err))
+			if tenant != "" {
+				err = fmt.Errorf("tenant %q: %w", tenant, err)
 			}
-			return
+			return nil, err
 		}
-	} else {
-		return
+		t := p.newTenant(cp.Rules, cp.Dedup)
+		t.alerts, t.rates = cp.Alerts, cp.Rates
+		// Rates of derived metrics that are no longer configured.
+		for id := range t.rates {
+			if _, ok := rates[id]; !ok {
+				delete(t.rates, id)
+			}
+		}
+		tenants[tenant] = t
+	}
+	return tenants, nil
+}
+
+// loadPrimary reads the checkpoint from the primary store, retrying with
+// exponential backoff when the policy asks for it.
+func (p *simpleProcessor) loadPrimary(ctx context.Context) ([]byte, error) {
+	data, err := p.primary.load(ctx, checkpointKey)
+	if err == nil || p.cfg.StorageFailure.Policy != StorageFailureRetry {
+		return data, err
 	}
 
-	if err := json.Unmarshal(data, &p.aggregations); err != nil {
-		p.logger.Error("Failed to unmarshal checkpoint", zap.Error(err))
+	retry := p.cfg.StorageFailure.Retry
+	interval := retry.InitialInterval
+	deadline := time.Now().Add(retry.MaxElapsedTime)
+	for {
+		if retry.MaxElapsedTime > 0 && time.Now().Add(interval).After(deadline) {
+			return nil, err
+		}
+		p.health.fail(healthLoad, err)
+		p.logger.Warn("Failed to read checkpoint, retrying",
+			zap.Stringer("store", p.primary), zap.Duration("interval", interval), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(interval):
+		}
+
+		data, err = p.primary.load(ctx, checkpointKey)
+		if err == nil {
+			p.health.recover(healthLoad)
+			return data, nil
+		}
+		interval *= 2
+		if retry.MaxInterval > 0 && interval > retry.MaxInterval {
+			interval = retry.MaxInterval
+		}
 	}
 }
 
-func (p *simpleProcessor) saveState(ctx context.Context) {
+// isLoaded reports whether the stored state was read, by Start or when
+// reconciling.
+func (p *simpleProcessor) isLoaded() bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.loaded
+}
+
+func (p *simpleProcessor) saveState(ctx context.Context) {
+	p.lock(ctx, opCheckpoint)
+	defer p.mu.Unlock()
 	p.saveStateLocked(ctx)
 }
 
-func (p *simpleProcessor) saveStateLocked(ctx context.Context) {
-	if p.storageClient == nil && p.checkpointFile == "" {
-		return
+// saveStateLocked writes a checkpoint to every configured store and returns
+// the errors of the writes that failed.
+func (p *simpleProcessor) saveStateLocked(ctx context.Context) error {
+	if p.corrupt {
+		return errCorruptState
+	}
+	if p.primary == nil {
+		return nil
 	}
 
-	data, err := json.Marshal(p.aggregations)
+	if p.degraded {
+		p.reconcileLocked(ctx)
+	}
+
+	// Expired hashes would only be forgotten on the next data point.
+	now := time.Now()
+	for _, t := range p.tenants {
+		if t.dedup != nil {
+			t.dedup.expire(now)
+		}
+	}
+	docs, err := p.encodeTenantsLocked()
 	if err != nil {
 		p.logger.Error("Failed to marshal checkpoint", zap.Error(err))
-		return
+		return err
+	}
+
+	var errs []error
+	if !p.degraded {
+		if err := p.saveTo(ctx, p.primary, docs); err != nil {
+			errs = append(errs, err)
+			p.logger.Error("Failed to write checkpoint", zap.Stringer("store", p.primary), zap.Error(err))
+			if p.secondary != nil {
+				p.logger.Warn("Degrading to secondary store", zap.Stringer("store", p.secondary))
+				p.degraded = true
+				p.health.fail(healthDegraded, err)
+			} else {
+				p.health.fail(healthCheckpoint, err)
+			}
+		} else {
+			p.health.recover(healthCheckpoint)
+		}
 	}
+	if p.secondary != nil {
+		if err := p.saveTo(ctx, p.secondary, docs); err != nil {
+			errs = append(errs, err)
+			p.logger.Error("Failed to write checkpoint", zap.Stringer("store", p.secondary), zap.Error(err))
+			p.health.fail(healthCheckpointSecondary, err)
+		} else {
+			p.health.recover(healthCheckpointSecondary)
+		}
+	}
+	if len(errs) == 0 {
+		p.deleteEvictedLocked(ctx)
+	}
+	return errors.Join(errs...)
+}
 
-	if p.storageClient != nil {
-		if err := p.storageClient.Set(ctx, "aggregations", data); err != nil {
-			p.logger.Error("Failed to write checkpoint to storage", zap.Error(err))
+// checkpointDocument is an encoded checkpoint and the key it is stored under.
+type checkpointDocument struct {
+	key  string
+	data []byte
+}
+
+// encodeTenantsLocked encodes the checkpoint of every tenant. The main
+// checkpoint lists the other tenants and comes last, so it never lists a
+// tenant whose checkpoint wasn't written.
+func (p *simpleProcessor) encodeTenantsLocked() ([]checkpointDocument, error) {
+	var docs []checkpointDocument
+	var tenants []string
+	for _, tenant := range sortedKeys(p.tenants) {
+		if tenant == "" {
+			continue
 		}
-	} else if p.checkpointFile != "" {
-		if err := os.WriteFile(p.checkpointFile, data, 0644); err != nil {
-			p.logger.Error("Failed to write checkpoint file", zap.Error(err))
+		t := p.tenants[tenant]
+		data, err := encodeCheckpoint(&checkpoint{Rules: t.rules, Dedup: t.dedup, Alerts: t.alerts, Rates: t.rates})
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tenant, err)
 		}
+		docs = append(docs, checkpointDocument{key: tenantKey(tenant), data: data})
+		tenants = append(tenants, tenant)
+	}
+
+	main := &checkpoint{Rules: make(map[string]*ruleState), Tenants: tenants}
+	if t, ok := p.tenants[""]; ok {
+		main.Rules, main.Dedup, main.Alerts, main.Rates = t.rules, t.dedup, t.alerts, t.rates
+	}
+	data, err := encodeCheckpoint(main)
+	if err != nil {
+		return nil, err
 	}
+	return append(docs, checkpointDocument{key: checkpointKey, data: data}), nil
 }
 
+// saveTo writes the encoded checkpoints to store and records how it went.
+func (p *simpleProcessor) saveTo(ctx context.Context, store stateStore, docs []checkpointDocument) error {
+	start := time.Now()
+	size := 0
+	var err error
+	for _, doc := range docs {
+		if err = store.save(ctx, doc.key, doc.data); err != nil {
+			break
+		}
+		size += len(doc.data)
+	}
+	p.telemetry.recordCheckpoint(ctx, store, size, time.Since(start), err)
+	p.status.recordCheckpoint(store, start, time.Since(start), size, err)
+	return err
+}
+
+// reconcileLocked checks whether the primary store is reachable again and
+// merges whatever it holds into the in-memory state. Counters are cumulative,
+// so the larger value of the two is the one that has seen more data.
+func (p *simpleProcessor) reconcileLocked(ctx context.Context) {
+	data, err := p.primary.load(ctx, checkpointKey)
+	if err != nil {
+		return
+	}
+	docs, err := loadTenantCheckpoints(ctx, p.primary, data)
+	if err != nil {
+		return
+	}
+	if docs != nil {
+		stored, err := p.decodeTenants(docs)
+		if err != nil {
+			p.logger.Error("Failed to unmarshal checkpoint while reconciling, overwriting it", zap.Stringer("store", p.primary), zap.Error(err))
+		}
+		for tenant, s := range stored {
+			t := p.tenantLocked(tenant)
+			if err := mergeState(t.rules, s.rules); err != nil {
+				p.logger.Error("Failed to merge checkpoint while reconciling", zap.Stringer("store", p.primary),
+					zap.String("tenant", tenant), zap.Error(err))
+			}
+			if t.dedup != nil && s.dedup != nil {
+				t.dedup.merge(s.dedup)
+			}
+			// The alerts and rates evaluated in memory are newer than the
+			// stored ones.
+			if t.alerts == nil {
+				t.alerts = s.alerts
+			}
+			if t.rates == nil {
+				t.rates = s.rates
+			}
+		}
+	}
+	p.degraded, p.loaded = false, true
+	p.health.recover(healthDegraded)
+	p.health.recover(healthLoad)
+	p.logger.Info("Primary store is available again, reconciled checkpoint", zap.Stringer("store", p.primary))
+}
+
+// flushInterval is how often the aggregations are flushed.
+const flushInterval = 5 * time.Second
+
 func (p *simpleProcessor) flushLoop() {
-	// Flush every 5 seconds
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(flushInterval)
 	defer ticker.Stop()
 
 	for {
@@ -181,39 +723,144 @@ func (p *simpleProcessor) flushLoop() {
 }
 
 func (p *simpleProcessor) flush() {
-	p.mu.Lock()
-	// Update checkpoint
-	p.saveStateLocked(context.Background())
-
-	if len(p.aggregations) == 0 {
+	ctx := context.Background()
+	start := time.Now()
+	p.lock(ctx, opFlush)
+	if p.corrupt {
+		// Flushing empty state would emit counters that went back to zero.
 		p.mu.Unlock()
 		return
 	}
+	p.evictIdleTenantsLocked(start)
+	out := newOutput(p.identity, p.cfg.Tenancy)
+	// Alerts are evaluated before the checkpoint, so it records what fired.
+	// Transitions stay pending in it until their records are delivered.
+	events := p.evaluateAlertsLocked(out, start)
 
-	// Construct new metrics batch
-	md := pmetric.NewMetrics()
-	rm := md.ResourceMetrics().AppendEmpty()
-	sm := rm.ScopeMetrics().AppendEmpty()
-	sm.Scope().SetName("simple-aggregator")
-
-	m := sm.Metrics().AppendEmpty()
-	m.SetName("work_done_batched")
-	m.SetUnit("1")
-	sum := m.SetEmptySum()
-	sum.SetIsMonotonic(true)
-	sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	// Update checkpoint
+	p.saveStateLocked(ctx)
 
-	for workType, count := range p.aggregations {
-		dp := sum.DataPoints().AppendEmpty()
-		dp.Attributes().PutStr("work.type", workType)
-		dp.SetIntValue(count)
+	// Construct new metrics batch
+	byName := make(map[string]*rule, len(p.rules))
+	for _, r := range p.rules {
+		byName[r.Name] = r
+	}
+	for tenant, t := range p.tenants {
+		for _, r := range p.rules {
+			state, ok := t.rules[r.Name]
+			if !ok || len(state.Series) == 0 {
+				continue
+			}
+			if err := r.appendMetric(out, tenant, state, start); err != nil {
+				p.logger.Warn("Failed to combine panes of a window", zap.String("rule", r.Name), zap.Error(err))
+			}
+		}
+		for _, d := range p.derived {
+			d.appendMetricLocked(out, tenant, t, byName, start)
+		}
+	}
+	if p.cfg.Recording != nil {
+		p.recordLocked(out, start)
 	}
 
-	// Do not reset aggregations for cumulative metrics
+	// Lifetime aggregations are cumulative and never reset, closed windows
+	// were dropped from the state by appendMetric.
 	p.mu.Unlock()
 
-	// Use background context as the original request context is long gone
-	if err := p.next.ConsumeMetrics(context.Background(), md); err != nil {
+	p.sendAlerts(ctx, out.ld, events)
+	md := out.md
+	// Flushes without data points are recorded too, so the last flush of an
+	// idle processor stays current.
+	var err error
+	if md.DataPointCount() > 0 {
+		// Use background context as the original request context is long gone
+		err = p.next.ConsumeMetrics(ctx, md)
+	}
+	p.telemetry.recordFlush(ctx, time.Since(start), err)
+	p.status.recordFlush(start, time.Since(start), md.DataPointCount(), err)
+	if err != nil {
 		p.logger.Error("Failed to flush metrics", zap.Error(err))
+		p.health.fail(healthFlush, err)
+	} else {
+		p.health.recover(healthFlush)
+	}
+}
+
+// evaluateAlertsLocked evaluates every alert for every tenant and adds the
+// records of the series that started or stopped firing to out.
+func (p *simpleProcessor) evaluateAlertsLocked(out *output, now time.Time) []alertEvent {
+	var events []alertEvent
+	for _, a := range p.alerts {
+		for _, tenant := range sortedKeys(p.tenants) {
+			for _, ev := range a.evaluateLocked(tenant, p.tenants[tenant], now) {
+				if !ev.retry {
+					state := alertResolved
+					if ev.series.Firing {
+						state = alertFiring
+					}
+					p.logger.Info("Alert "+state, zap.String("alert", a.Name), zap.String("tenant", tenant),
+						zap.Any("attributes", ev.series.Attributes), zap.Float64("value", ev.value))
+				}
+				a.appendRecord(out, ev, now)
+				events = append(events, ev)
+			}
+		}
+	}
+	return events
+}
+
+// sendAlerts sends the records of alert transitions and marks them delivered
+// once the logs output accepted them. Undelivered ones are sent again on the
+// next flush.
+func (p *simpleProcessor) sendAlerts(ctx context.Context, ld plog.Logs, events []alertEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if err := p.sendLogs(ctx, ld, "alerts"); err != nil {
+		return
+	}
+	p.lock(ctx, opFlush)
+	defer p.mu.Unlock()
+	for _, ev := range events {
+		ev.series.Pending = false
+	}
+}
+
+// sendLogs sends records, such as those of quarantined data points, to the
+// logs output.
+func (p *simpleProcessor) sendLogs(ctx context.Context, ld plog.Logs, what string) error {
+	if ld.LogRecordCount() == 0 || p.logsOut == nil {
+		return nil
+	}
+	if err := p.logsOut.ConsumeLogs(ctx, ld); err != nil {
+		p.logger.Error("Failed to send "+what, zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// numberDataPoints returns the data points of a sum or gauge.
+func numberDataPoints(m pmetric.Metric) pmetric.NumberDataPointSlice {
+	if m.Type() == pmetric.MetricTypeGauge {
+		return m.Gauge().DataPoints()
+	}
+	return m.Sum().DataPoints()
+}
+
+// dataPointCount returns the number of data points of m, whatever its type.
+func dataPointCount(m pmetric.Metric) int {
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		return m.Gauge().DataPoints().Len()
+	case pmetric.MetricTypeSum:
+		return m.Sum().DataPoints().Len()
+	case pmetric.MetricTypeHistogram:
+		return m.Histogram().DataPoints().Len()
+	case pmetric.MetricTypeExponentialHistogram:
+		return m.ExponentialHistogram().DataPoints().Len()
+	case pmetric.MetricTypeSummary:
+		return m.Summary().DataPoints().Len()
+	default:
+		return 0
 	}
 }
@@ -0,0 +1,678 @@
+package simpleprocessor
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// promNode is a node of a recording rule expression, a subset of PromQL.
+type promNode interface{}
+
+type promNumber float64
+
+// promSelector selects stored series by metric name and labels, the latest
+// sample of each or, with a range, the samples within it.
+type promSelector struct {
+	name     string
+	matchers []promMatcher
+	rng      time.Duration // zero for an instant selector
+}
+
+type promMatcher struct {
+	label string
+	op    string // =, !=, =~ or !~
+	value string
+	re    *regexp.Regexp // for =~ and !~
+}
+
+func (m *promMatcher) matches(labels map[string]string) bool {
+	v := labels[m.label]
+	switch m.op {
+	case "=":
+		return v == m.value
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+type promCall struct {
+	fn   string
+	args []promNode
+}
+
+type promAggregate struct {
+	op string
+	by []string
+	x  promNode
+}
+
+// promFunctions are the supported functions and the kinds of their
+// arguments.
+var promFunctions = map[string][]string{
+	"rate":               {promKindRange},
+	"increase":           {promKindRange},
+	"max_over_time":      {promKindRange},
+	"histogram_quantile": {promKindScalar, promKindVector},
+}
+
+var promAggregations = map[string]bool{"sum": true, "min": true, "max": true, "avg": true, "count": true}
+
+// Kinds of expression values.
+const (
+	promKindScalar = "a scalar"
+	promKindVector = "an instant vector"
+	promKindRange  = "a range vector"
+)
+
+func promKind(n promNode) string {
+	switch n := n.(type) {
+	case promNumber:
+		return promKindScalar
+	case *promSelector:
+		if n.rng > 0 {
+			return promKindRange
+		}
+	}
+	return promKindVector
+}
+
+// parsePromQL parses a recording rule expression, which must be an instant
+// vector.
+func parsePromQL(s string) (promNode, error) {
+	p := &promParser{s: s}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != 0 {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	if kind := promKind(n); kind != promKindVector {
+		return nil, fmt.Errorf("expression must be %s, not %s", promKindVector, kind)
+	}
+	return n, nil
+}
+
+// promRanges returns the ranges of the range selectors of an expression.
+func promRanges(n promNode) []time.Duration {
+	switch n := n.(type) {
+	case *promSelector:
+		if n.rng > 0 {
+			return []time.Duration{n.rng}
+		}
+	case *promCall:
+		var ranges []time.Duration
+		for _, arg := range n.args {
+			ranges = append(ranges, promRanges(arg)...)
+		}
+		return ranges
+	case *promAggregate:
+		return promRanges(n.x)
+	}
+	return nil
+}
+
+type promParser struct {
+	s   string
+	pos int
+}
+
+func (p *promParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// peek returns the next character after spaces, or 0 at the end.
+func (p *promParser) peek() byte {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *promParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// name reads a metric or label name. Metric names of recording rules
+// conventionally contain colons, attribute names dots.
+func (p *promParser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !isIdentStart(c) && !isDigit(c) && c != ':' && c != '.' {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// keyword consumes the next name if it is w.
+func (p *promParser) keyword(w string) bool {
+	start := p.pos
+	if isIdentStart(p.peek()) && p.name() == w {
+		return true
+	}
+	p.pos = start
+	return false
+}
+
+func (p *promParser) expr() (promNode, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(')')
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && (isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return promNumber(v), nil
+	case isIdentStart(c) || c == ':':
+		name := p.name()
+		if promAggregations[name] {
+			return p.aggregate(name)
+		}
+		if kinds, ok := promFunctions[name]; ok && p.peek() == '(' {
+			return p.call(name, kinds)
+		}
+		return p.selector(name)
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// aggregate parses an aggregation, with its by clause before or after the
+// argument.
+func (p *promParser) aggregate(op string) (promNode, error) {
+	agg := &promAggregate{op: op}
+	var err error
+	if p.keyword("by") {
+		if agg.by, err = p.labels(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if agg.x, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	if agg.by == nil && p.keyword("by") {
+		if agg.by, err = p.labels(); err != nil {
+			return nil, err
+		}
+	}
+	if kind := promKind(agg.x); kind != promKindVector {
+		return nil, fmt.Errorf("%s needs %s, not %s", op, promKindVector, kind)
+	}
+	return agg, nil
+}
+
+// labels parses a parenthesized list of label names.
+func (p *promParser) labels() ([]string, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for p.peek() != ')' {
+		if len(labels) > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		if !isIdentStart(p.peek()) {
+			return nil, p.errorf("expected a label name")
+		}
+		labels = append(labels, p.name())
+	}
+	p.pos++
+	return labels, nil
+}
+
+func (p *promParser) call(fn string, kinds []string) (promNode, error) {
+	p.pos++ // (
+	call := &promCall{fn: fn}
+	for p.peek() != ')' {
+		if len(call.args) > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	p.pos++
+	if len(call.args) != len(kinds) {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", fn, len(kinds), len(call.args))
+	}
+	for i, arg := range call.args {
+		if kind := promKind(arg); kind != kinds[i] {
+			return nil, fmt.Errorf("argument %d of %s must be %s, not %s", i+1, fn, kinds[i], kind)
+		}
+	}
+	return call, nil
+}
+
+func (p *promParser) selector(name string) (promNode, error) {
+	sel := &promSelector{name: name}
+	if p.peek() == '{' {
+		p.pos++
+		for p.peek() != '}' {
+			if len(sel.matchers) > 0 {
+				if err := p.expect(','); err != nil {
+					return nil, err
+				}
+			}
+			m, err := p.matcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.matchers = append(sel.matchers, m)
+		}
+		p.pos++
+	}
+	if p.peek() == '[' {
+		p.pos++
+		end := strings.IndexByte(p.s[p.pos:], ']')
+		if end < 0 {
+			return nil, p.errorf("expected ']'")
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(p.s[p.pos : p.pos+end]))
+		if err != nil || d <= 0 {
+			return nil, p.errorf("invalid range %q", p.s[p.pos:p.pos+end])
+		}
+		sel.rng = d
+		p.pos += end + 1
+	}
+	return sel, nil
+}
+
+func (p *promParser) matcher() (promMatcher, error) {
+	if !isIdentStart(p.peek()) {
+		return promMatcher{}, p.errorf("expected a label name")
+	}
+	m := promMatcher{label: p.name()}
+	p.peek()
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			m.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if m.op == "" {
+		return m, p.errorf("expected =, !=, =~ or !~")
+	}
+	value, err := p.quoted()
+	if err != nil {
+		return m, err
+	}
+	m.value = value
+	if m.op == "=~" || m.op == "!~" {
+		// Label matchers are anchored, as in PromQL.
+		if m.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+			return m, p.errorf("invalid regular expression: %v", err)
+		}
+	}
+	return m, nil
+}
+
+// quoted reads a string in double or single quotes.
+func (p *promParser) quoted() (string, error) {
+	switch p.peek() {
+	case '"':
+		q, err := strconv.QuotedPrefix(p.s[p.pos:])
+		if err != nil {
+			return "", p.errorf("invalid string: %v", err)
+		}
+		p.pos += len(q)
+		return strconv.Unquote(q)
+	case '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end < 0 {
+			return "", p.errorf("unterminated string")
+		}
+		v := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return v, nil
+	default:
+		return "", p.errorf("expected a quoted value")
+	}
+}
+
+// promHistogram is an exponential histogram sample, with counts that may
+// be fractional once rates are taken.
+type promHistogram struct {
+	scale      int32
+	count, sum float64
+	zero       float64
+	positive   map[int32]float64 // Count by bucket index
+	negative   map[int32]float64
+}
+
+func newPromHistogram(scale int32) *promHistogram {
+	return &promHistogram{scale: scale, positive: make(map[int32]float64), negative: make(map[int32]float64)}
+}
+
+// downscaled returns a copy of h at a scale no larger than its own, merging
+// neighboring buckets.
+func (h *promHistogram) downscaled(scale int32) *promHistogram {
+	shift := h.scale - scale
+	out := newPromHistogram(scale)
+	out.count, out.sum, out.zero = h.count, h.sum, h.zero
+	for i, c := range h.positive {
+		out.positive[i>>shift] += c
+	}
+	for i, c := range h.negative {
+		out.negative[i>>shift] += c
+	}
+	return out
+}
+
+// add returns h + f*o at the coarser of their scales.
+func (h *promHistogram) add(o *promHistogram, f float64) *promHistogram {
+	scale := min(h.scale, o.scale)
+	out := h.downscaled(scale)
+	other := o.downscaled(scale)
+	out.count += f * other.count
+	out.sum += f * other.sum
+	out.zero += f * other.zero
+	for i, c := range other.positive {
+		out.positive[i] += f * c
+	}
+	for i, c := range other.negative {
+		out.negative[i] += f * c
+	}
+	return out
+}
+
+// quantile estimates the q quantile, interpolating linearly within the
+// bucket it falls into.
+func (h *promHistogram) quantile(q float64) float64 {
+	switch {
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(1)
+	case h.count <= 0:
+		return math.NaN()
+	}
+	base := math.Exp2(math.Exp2(-float64(h.scale)))
+	rank := q * h.count
+	seen := 0.0
+	// Negative buckets hold larger magnitudes at larger indexes, so they
+	// are visited from the largest index up to zero.
+	negative := slices.Sorted(maps.Keys(h.negative))
+	slices.Reverse(negative)
+	for _, i := range negative {
+		c := h.negative[i]
+		if c > 0 && seen+c >= rank {
+			lower, upper := -math.Pow(base, float64(i+1)), -math.Pow(base, float64(i))
+			return lower + (upper-lower)*(rank-seen)/c
+		}
+		seen += c
+	}
+	if h.zero > 0 && seen+h.zero >= rank {
+		return 0
+	}
+	seen += h.zero
+	var upper float64
+	for _, i := range slices.Sorted(maps.Keys(h.positive)) {
+		c := h.positive[i]
+		lower := math.Pow(base, float64(i))
+		upper = math.Pow(base, float64(i+1))
+		if c > 0 && seen+c >= rank {
+			return lower + (upper-lower)*(rank-seen)/c
+		}
+		seen += c
+	}
+	return upper
+}
+
+// promSample is a float or histogram sample of a stored series.
+type promSample struct {
+	t time.Time
+	f float64
+	h *promHistogram // nil for floats
+}
+
+// promSeries is an element of an instant vector.
+type promSeries struct {
+	labels map[string]string
+	promSample
+}
+
+// promEvaluator evaluates expressions against the samples of one tenant.
+type promEvaluator struct {
+	store    *sampleStore
+	now      time.Time
+	lookback time.Duration // how old the latest sample of an instant selector may be
+}
+
+func (e *promEvaluator) eval(n promNode) []promSeries {
+	switch n := n.(type) {
+	case *promSelector:
+		var out []promSeries
+		for _, s := range e.store.selectSeries(n) {
+			if last, ok := s.ring.last(); ok && e.now.Sub(last.t) <= e.lookback {
+				out = append(out, promSeries{labels: s.labels, promSample: last})
+			}
+		}
+		return out
+	case *promCall:
+		return e.call(n)
+	case *promAggregate:
+		return e.aggregate(n)
+	default:
+		return nil
+	}
+}
+
+func (e *promEvaluator) call(c *promCall) []promSeries {
+	var out []promSeries
+	if c.fn == "histogram_quantile" {
+		q := float64(c.args[0].(promNumber))
+		for _, s := range e.eval(c.args[1]) {
+			if s.h != nil {
+				out = append(out, promSeries{labels: s.labels, promSample: promSample{t: e.now, f: s.h.quantile(q)}})
+			}
+		}
+		return out
+	}
+
+	sel := c.args[0].(*promSelector)
+	for _, s := range e.store.selectSeries(sel) {
+		samples := s.ring.since(e.now.Add(-sel.rng))
+		var v promSample
+		var ok bool
+		switch c.fn {
+		case "max_over_time":
+			v, ok = maxOverTime(samples)
+		default:
+			v, ok = increase(samples, s.delta)
+			if ok && c.fn == "rate" {
+				// Deltas cover the range, cumulative samples the time
+				// between the first and the last.
+				d := sel.rng.Seconds()
+				if !s.delta {
+					d = samples[len(samples)-1].t.Sub(samples[0].t).Seconds()
+				}
+				// Samples stored at the same instant, e.g. by two flushes
+				// within a clock tick, have no rate.
+				if ok = d > 0; ok {
+					v = scaleSample(v, 1/d)
+				}
+			}
+		}
+		if ok {
+			v.t = e.now
+			out = append(out, promSeries{labels: s.labels, promSample: v})
+		}
+	}
+	return out
+}
+
+// increase returns how much a series grew over its samples: the sum of
+// deltas, or the growth between the first and the last cumulative sample
+// counting resets, without extrapolating to the whole range.
+func increase(samples []promSample, delta bool) (promSample, bool) {
+	if len(samples) == 0 || !delta && len(samples) < 2 {
+		return promSample{}, false
+	}
+	var out promSample
+	if samples[0].h != nil {
+		out.h = newPromHistogram(samples[0].h.scale)
+	}
+	for i, s := range samples {
+		if (s.h == nil) != (out.h == nil) {
+			// The series changed type.
+			return promSample{}, false
+		}
+		grown := s
+		if !delta {
+			if i == 0 {
+				continue
+			}
+			grown = growth(samples[i-1], s)
+		}
+		if out.h != nil {
+			out.h = out.h.add(grown.h, 1)
+		} else {
+			out.f += grown.f
+		}
+	}
+	return out, true
+}
+
+// growth returns how much a cumulative series grew from prev to s. A
+// decrease is a reset, after which the series counted up to s from zero.
+func growth(prev, s promSample) promSample {
+	if s.h != nil {
+		if s.h.count < prev.h.count {
+			return s
+		}
+		return promSample{h: s.h.add(prev.h, -1)}
+	}
+	if s.f < prev.f {
+		return s
+	}
+	return promSample{f: s.f - prev.f}
+}
+
+func scaleSample(s promSample, f float64) promSample {
+	if s.h != nil {
+		return promSample{h: newPromHistogram(s.h.scale).add(s.h, f)}
+	}
+	return promSample{f: s.f * f}
+}
+
+func maxOverTime(samples []promSample) (promSample, bool) {
+	out := promSample{f: math.Inf(-1)}
+	ok := false
+	for _, s := range samples {
+		if s.h == nil {
+			out.f = max(out.f, s.f)
+			ok = true
+		}
+	}
+	return out, ok
+}
+
+// aggregate groups a vector by the by labels. Histograms are only summed.
+func (e *promEvaluator) aggregate(a *promAggregate) []promSeries {
+	type group struct {
+		labels map[string]string
+		n      int
+		floats []float64
+		h      *promHistogram
+	}
+	groups := make(map[string]*group)
+	for _, s := range e.eval(a.x) {
+		labels := make(map[string]string, len(a.by))
+		for _, k := range a.by {
+			if v, ok := s.labels[k]; ok {
+				labels[k] = v
+			}
+		}
+		key := seriesKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+		}
+		g.n++
+		switch {
+		case s.h == nil:
+			g.floats = append(g.floats, s.f)
+		case g.h == nil:
+			g.h = s.h
+		default:
+			g.h = g.h.add(s.h, 1)
+		}
+	}
+
+	var out []promSeries
+	for _, key := range sortedKeys(groups) {
+		g := groups[key]
+		v := promSample{t: e.now}
+		switch {
+		case a.op == "count":
+			v.f = float64(g.n)
+		case a.op == "sum" && g.h != nil:
+			v.h = g.h
+		case len(g.floats) == 0:
+			continue
+		case a.op == "sum":
+			for _, f := range g.floats {
+				v.f += f
+			}
+		case a.op == "avg":
+			for _, f := range g.floats {
+				v.f += f
+			}
+			v.f /= float64(len(g.floats))
+		case a.op == "min":
+			v.f = slices.Min(g.floats)
+		default:
+			v.f = slices.Max(g.floats)
+		}
+		out = append(out, promSeries{labels: g.labels, promSample: v})
+	}
+	return out
+}
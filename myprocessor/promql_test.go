@@ -0,0 +1,268 @@
+package simpleprocessor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParsePromQL(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "work_done"},
+		{expr: `work_done{work.type="manual"}`},
+		{expr: `work_done{work.type!="manual", status=~"fail.*", host!~'h[0-9]'}`},
+		{expr: "rate(work_done[5m])"},
+		{expr: "increase(work_done[1h])"},
+		{expr: "max_over_time(queue_depth[30s])"},
+		{expr: "sum by (work.type) (rate(work_done[5m]))"},
+		{expr: "sum(rate(work_done[5m])) by (work.type)"},
+		{expr: "count(work_done)"},
+		{expr: "histogram_quantile(0.99, sum by (service.name) (rate(latency[5m])))"},
+		{expr: "(work_done)"},
+		{expr: "", wantErr: true},
+		{expr: "1", wantErr: true},
+		{expr: "work_done[5m]", wantErr: true},
+		{expr: "rate(work_done)", wantErr: true},
+		{expr: "rate(work_done[5m], 1)", wantErr: true},
+		{expr: "histogram_quantile(work_done, 0.5)", wantErr: true},
+		{expr: "sum(work_done[5m])", wantErr: true},
+		{expr: "sum by (work.type (work_done)", wantErr: true},
+		{expr: `work_done{work.type="manual"`, wantErr: true},
+		{expr: "work_done{work.type=manual}", wantErr: true},
+		{expr: `work_done{work.type=~"("}`, wantErr: true},
+		{expr: "work_done[0s]", wantErr: true},
+		{expr: "work_done[5x]", wantErr: true},
+		{expr: "rate(work_done[5m]) )", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parsePromQL(tt.expr)
+			if tt.wantErr && err == nil {
+				t.Fatalf("parsePromQL(%q) succeeded, want an error", tt.expr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("parsePromQL(%q): %v", tt.expr, err)
+			}
+		})
+	}
+}
+
+func TestIncrease(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	floats := func(values ...float64) []promSample {
+		samples := make([]promSample, len(values))
+		for i, v := range values {
+			samples[i] = promSample{t: start.Add(time.Duration(i) * 10 * time.Second), f: v}
+		}
+		return samples
+	}
+	tests := []struct {
+		name    string
+		samples []promSample
+		delta   bool
+		want    float64
+		wantOK  bool
+	}{
+		{name: "cumulative", samples: floats(10, 15, 30), want: 20, wantOK: true},
+		{name: "cumulative reset", samples: floats(10, 40, 5, 25), want: 55, wantOK: true},
+		{name: "reset to zero", samples: floats(10, 0, 3), want: 3, wantOK: true},
+		{name: "cumulative single sample", samples: floats(10), wantOK: false},
+		{name: "no samples", samples: nil, wantOK: false},
+		{name: "deltas", samples: floats(1, 2, 3), delta: true, want: 6, wantOK: true},
+		{name: "single delta", samples: floats(4), delta: true, want: 4, wantOK: true},
+		{
+			name:    "type change",
+			samples: []promSample{{t: start, f: 1}, {t: start.Add(time.Second), h: newPromHistogram(0)}},
+			wantOK:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := increase(tt.samples, tt.delta)
+			if ok != tt.wantOK {
+				t.Fatalf("increase ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got.f != tt.want {
+				t.Fatalf("increase = %g, want %g", got.f, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncreaseHistogramReset(t *testing.T) {
+	hist := func(count float64) *promHistogram {
+		h := newPromHistogram(0)
+		h.count, h.sum = count, count
+		h.positive[0] = count
+		return h
+	}
+	start := time.Unix(1_700_000_000, 0)
+	samples := []promSample{
+		{t: start, h: hist(10)},
+		{t: start.Add(10 * time.Second), h: hist(30)},
+		{t: start.Add(20 * time.Second), h: hist(5)},
+	}
+	got, ok := increase(samples, false)
+	if !ok || got.h == nil {
+		t.Fatalf("increase = %+v (%v), want a histogram", got, ok)
+	}
+	if got.h.count != 25 || got.h.positive[0] != 25 {
+		t.Fatalf("increase count = %g, bucket = %g, want 25", got.h.count, got.h.positive[0])
+	}
+}
+
+// evalPromQL stores samples of one series and evaluates expr at now.
+func evalPromQL(t *testing.T, expr string, delta bool, samples []promSample, now time.Time) []promSeries {
+	t.Helper()
+	n, err := parsePromQL(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newSampleStore(time.Hour)
+	for _, s := range samples {
+		store.add("work_done", map[string]string{"work.type": "manual"}, delta, s)
+	}
+	e := &promEvaluator{store: store, now: now, lookback: 5 * time.Minute}
+	return e.eval(n)
+}
+
+func TestRate(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	at := func(seconds int, v float64) promSample {
+		return promSample{t: start.Add(time.Duration(seconds) * time.Second), f: v}
+	}
+	tests := []struct {
+		name    string
+		expr    string
+		delta   bool
+		samples []promSample
+		want    []float64
+	}{
+		{
+			name:    "cumulative over the time between samples",
+			expr:    "rate(work_done[1m])",
+			samples: []promSample{at(0, 100), at(10, 150), at(20, 200)},
+			want:    []float64{5},
+		},
+		{
+			name:    "counter reset",
+			expr:    "rate(work_done[1m])",
+			samples: []promSample{at(0, 100), at(10, 150), at(20, 30)},
+			want:    []float64{4},
+		},
+		{
+			name:    "deltas over the range",
+			expr:    "rate(work_done[1m])",
+			delta:   true,
+			samples: []promSample{at(0, 30), at(10, 30)},
+			want:    []float64{1},
+		},
+		{
+			name:    "samples at the same instant",
+			expr:    "rate(work_done[1m])",
+			samples: []promSample{at(20, 100), at(20, 150)},
+			want:    nil,
+		},
+		{
+			name:    "samples outside the range",
+			expr:    "rate(work_done[15s])",
+			samples: []promSample{at(0, 100), at(10, 150), at(20, 200)},
+			want:    nil,
+		},
+		{
+			name:    "increase with a reset",
+			expr:    "increase(work_done[1m])",
+			samples: []promSample{at(0, 100), at(10, 150), at(20, 30)},
+			want:    []float64{80},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evalPromQL(t, tt.expr, tt.delta, tt.samples, start.Add(25*time.Second))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d series, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if math.IsInf(got[i].f, 0) || math.IsNaN(got[i].f) || math.Abs(got[i].f-tt.want[i]) > 1e-9 {
+					t.Fatalf("series %d = %g, want %g", i, got[i].f, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	// At scale 0 bucket i holds values in (2^i, 2^(i+1)].
+	h := newPromHistogram(0)
+	h.count = 20
+	h.positive[0] = 10 // (1, 2]
+	h.positive[1] = 10 // (2, 4]
+
+	withZero := newPromHistogram(0)
+	withZero.count, withZero.zero = 20, 10
+	withZero.positive[2] = 10 // (4, 8]
+
+	withNegative := newPromHistogram(0)
+	withNegative.count = 20
+	withNegative.negative[1] = 10 // [-4, -2)
+	withNegative.positive[1] = 10 // (2, 4]
+
+	tests := []struct {
+		name string
+		h    *promHistogram
+		q    float64
+		want float64
+	}{
+		{name: "median", h: h, q: 0.5, want: 2},
+		{name: "interpolated", h: h, q: 0.75, want: 3},
+		{name: "max", h: h, q: 1, want: 4},
+		{name: "min", h: h, q: 0, want: 1},
+		{name: "below zero", h: h, q: -0.1, want: math.Inf(-1)},
+		{name: "above one", h: h, q: 1.1, want: math.Inf(1)},
+		{name: "empty", h: newPromHistogram(0), q: 0.5, want: math.NaN()},
+		{name: "zero bucket", h: withZero, q: 0.25, want: 0},
+		{name: "past the zero bucket", h: withZero, q: 0.75, want: 6},
+		{name: "negative", h: withNegative, q: 0.25, want: -3},
+		{name: "finer scale", h: h.add(newPromHistogram(1), 1), q: 0.5, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.h.quantile(tt.q)
+			if math.IsNaN(tt.want) {
+				if !math.IsNaN(got) {
+					t.Fatalf("quantile(%g) = %g, want NaN", tt.q, got)
+				}
+				return
+			}
+			if got != tt.want && math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("quantile(%g) = %g, want %g", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramQuantileOfRate(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	hist := func(lower, upper float64) *promHistogram {
+		h := newPromHistogram(0)
+		h.count = lower + upper
+		h.positive[0], h.positive[1] = lower, upper
+		return h
+	}
+	samples := []promSample{
+		{t: start, h: hist(100, 100)},
+		// 10 more in (1, 2] and 30 more in (2, 4].
+		{t: start.Add(10 * time.Second), h: hist(110, 130)},
+	}
+	got := evalPromQL(t, "histogram_quantile(0.5, rate(work_done[1m]))", false, samples, start.Add(15*time.Second))
+	if len(got) != 1 {
+		t.Fatalf("got %d series, want 1", len(got))
+	}
+	// The median of the increase is rank 20 of 40, half way into (2, 4].
+	if math.Abs(got[0].f-8.0/3) > 1e-9 {
+		t.Fatalf("quantile = %g, want %g", got[0].f, 8.0/3)
+	}
+}
@@ -0,0 +1,195 @@
+package simpleprocessor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/DataDog/sketches-go/ddsketch/mapping"
+	"github.com/DataDog/sketches-go/ddsketch/store"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Outputs of the quantile aggregation.
+const (
+	// QuantileOutputQuantiles flushes every configured quantile as a gauge
+	// data point with a quantile attribute.
+	QuantileOutputQuantiles = "quantiles"
+	// QuantileOutputExponentialHistogram flushes the whole sketch as a
+	// cumulative exponential histogram.
+	QuantileOutputExponentialHistogram = "exponential_histogram"
+)
+
+const defaultRelativeAccuracy = 0.01
+
+// The scales an exponential histogram can be flushed with.
+const (
+	minExpHistogramScale = -10
+	maxExpHistogramScale = 20
+)
+
+func defaultQuantiles() []float64 {
+	return []float64{0.5, 0.95, 0.99}
+}
+
+// QuantileConfig configures the quantile aggregation.
+type QuantileConfig struct {
+	// RelativeAccuracy bounds the relative error of every quantile, between 0
+	// and 1. Defaults to 0.01.
+	RelativeAccuracy float64 `mapstructure:"relative_accuracy"`
+
+	// Quantiles flushed with the quantiles output, each between 0 and 1.
+	// Defaults to 0.5, 0.95 and 0.99.
+	Quantiles []float64 `mapstructure:"quantiles"`
+
+	// Output is one of quantiles (default) or exponential_histogram.
+	Output string `mapstructure:"output"`
+}
+
+// Validate checks the configuration.
+func (c *QuantileConfig) Validate() error {
+	if c.RelativeAccuracy < 0 || c.RelativeAccuracy >= 1 {
+		return errors.New("relative_accuracy must be between 0 and 1")
+	}
+	for _, q := range c.Quantiles {
+		if q < 0 || q > 1 {
+			return errors.New("quantiles must be between 0 and 1")
+		}
+	}
+	switch c.Output {
+	case "", QuantileOutputQuantiles, QuantileOutputExponentialHistogram:
+	default:
+		return errors.New("output must be quantiles or exponential_histogram")
+	}
+	return nil
+}
+
+func (c *QuantileConfig) output() string {
+	if c.Output == "" {
+		return QuantileOutputQuantiles
+	}
+	return c.Output
+}
+
+func (c *QuantileConfig) quantiles() []float64 {
+	if len(c.Quantiles) == 0 {
+		return defaultQuantiles()
+	}
+	return c.Quantiles
+}
+
+// scale returns the coarsest exponential histogram scale whose buckets are
+// within the relative accuracy.
+func (c *QuantileConfig) scale() int32 {
+	accuracy := c.RelativeAccuracy
+	if accuracy == 0 {
+		accuracy = defaultRelativeAccuracy
+	}
+	gamma := (1 + accuracy) / (1 - accuracy)
+	scale := int32(math.Ceil(math.Log2(1 / math.Log2(gamma))))
+	return min(max(scale, minExpHistogramScale), maxExpHistogramScale)
+}
+
+// ddSketch is a DDSketch that is checkpointed in its binary form. Its buckets
+// are those of an exponential histogram of the given scale, so it converts to
+// one without losing accuracy.
+type ddSketch struct {
+	sketch *ddsketch.DDSketchWithExactSummaryStatistics
+	scale  int32
+}
+
+func newDDSketch(scale int32) *ddSketch {
+	m, err := mapping.NewLogarithmicMappingWithGamma(math.Exp2(math.Exp2(-float64(scale))), 0)
+	if err != nil {
+		// Gamma is always greater than 1.
+		panic(err)
+	}
+	sk := ddsketch.NewDDSketchWithExactSummaryStatistics(m, store.DefaultProvider)
+	return &ddSketch{sketch: sk, scale: scale}
+}
+
+func (d *ddSketch) add(v float64) error {
+	return d.sketch.Add(v)
+}
+
+func (d *ddSketch) count() float64 {
+	return d.sketch.GetCount()
+}
+
+func (d *ddSketch) quantiles(qs []float64) ([]float64, error) {
+	return d.sketch.GetValuesAtQuantiles(qs)
+}
+
+// merge folds other into d, so d holds the observations of both.
+func (d *ddSketch) merge(other *ddSketch) error {
+	if d.scale != other.scale {
+		return errors.New("sketches have different relative accuracies")
+	}
+	return d.sketch.MergeWith(other.sketch)
+}
+
+// copyTo fills dp with the sketch. The sketch's bucket k holds values in
+// [base^k, base^(k+1)), which is bucket k of the histogram up to its
+// inclusive upper bound.
+func (d *ddSketch) copyTo(dp pmetric.ExponentialHistogramDataPoint) {
+	dp.SetScale(d.scale)
+	dp.SetCount(uint64(math.Round(d.sketch.GetCount())))
+	dp.SetZeroCount(uint64(math.Round(d.sketch.GetZeroCount())))
+	dp.SetSum(d.sketch.GetSum())
+	if v, err := d.sketch.GetMinValue(); err == nil {
+		dp.SetMin(v)
+	}
+	if v, err := d.sketch.GetMaxValue(); err == nil {
+		dp.SetMax(v)
+	}
+	copyBuckets(d.sketch.GetPositiveValueStore(), dp.Positive())
+	copyBuckets(d.sketch.GetNegativeValueStore(), dp.Negative())
+}
+
+func copyBuckets(s store.Store, buckets pmetric.ExponentialHistogramDataPointBuckets) {
+	if s.IsEmpty() {
+		return
+	}
+	lo, _ := s.MinIndex()
+	hi, _ := s.MaxIndex()
+	counts := make([]uint64, hi-lo+1)
+	s.ForEach(func(index int, count float64) bool {
+		counts[index-lo] += uint64(math.Round(count))
+		return false
+	})
+	buckets.SetOffset(int32(lo))
+	buckets.BucketCounts().FromRaw(counts)
+}
+
+type ddSketchJSON struct {
+	Scale  int32  `json:"scale"`
+	Sketch []byte `json:"sketch"`
+}
+
+func (d *ddSketch) MarshalJSON() ([]byte, error) {
+	var data []byte
+	d.sketch.Encode(&data, true)
+	return json.Marshal(ddSketchJSON{Scale: d.scale, Sketch: data})
+}
+
+func (d *ddSketch) UnmarshalJSON(b []byte) error {
+	var v ddSketchJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Scale < minExpHistogramScale || v.Scale > maxExpHistogramScale {
+		return fmt.Errorf("quantile sketch has invalid scale %d", v.Scale)
+	}
+	*d = *newDDSketch(v.Scale)
+	return d.sketch.DecodeAndMergeWith(v.Sketch)
+}
+
+// numberValue returns the value of a data point as a float.
+func numberValue(dp pmetric.NumberDataPoint) float64 {
+	if dp.ValueType() == pmetric.NumberDataPointValueTypeDouble {
+		return dp.DoubleValue()
+	}
+	return float64(dp.IntValue())
+}
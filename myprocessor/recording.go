@@ -0,0 +1,301 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	defaultRecordingRetention = 15 * time.Minute
+	// recordingLookback is how old the latest sample of a series may be for
+	// an instant selector to find it, as in Prometheus.
+	recordingLookback = 5 * time.Minute
+)
+
+// RecordingConfig configures recording rules, which evaluate a subset of
+// PromQL against the samples of the last flushes.
+type RecordingConfig struct {
+	// Retention is how long flushed samples are kept for the rules, and the
+	// longest range they can use. Defaults to 15m.
+	Retention time.Duration `mapstructure:"retention"`
+
+	// Rules are evaluated in order at every flush, so a rule can use the
+	// results of those before it.
+	Rules []RecordingRuleConfig `mapstructure:"rules"`
+}
+
+// RecordingRuleConfig defines one recording rule.
+type RecordingRuleConfig struct {
+	// Record is the name of the metric the result is flushed as, e.g.
+	// work_type:work_done:rate5m.
+	Record string `mapstructure:"record"`
+
+	// Expr is the expression, e.g. sum by (work.type) (rate(work_done[5m])).
+	Expr string `mapstructure:"expr"`
+}
+
+func (c *RecordingConfig) retention() time.Duration {
+	if c.Retention == 0 {
+		return defaultRecordingRetention
+	}
+	return c.Retention
+}
+
+// Validate checks the configuration.
+func (c *RecordingConfig) Validate() error {
+	if c.Retention < 0 {
+		return errors.New("retention must not be negative")
+	}
+	if c.retention() < flushInterval {
+		return fmt.Errorf("retention must be at least the flush interval of %s", flushInterval)
+	}
+	records := make(map[string]struct{}, len(c.Rules))
+	for i, r := range c.Rules {
+		if r.Record == "" {
+			return fmt.Errorf("rules[%d]: record must be set", i)
+		}
+		if _, ok := records[r.Record]; ok {
+			return fmt.Errorf("rules[%d]: duplicate record %q", i, r.Record)
+		}
+		records[r.Record] = struct{}{}
+		expr, err := parsePromQL(r.Expr)
+		if err != nil {
+			return fmt.Errorf("rules[%d]: expr: %w", i, err)
+		}
+		for _, rng := range promRanges(expr) {
+			if rng > c.retention() {
+				return fmt.Errorf("rules[%d]: range %s is longer than the retention of %s", i, rng, c.retention())
+			}
+		}
+	}
+	return nil
+}
+
+// recordingRule is a RecordingRuleConfig ready to be evaluated.
+type recordingRule struct {
+	RecordingRuleConfig
+
+	expr promNode
+}
+
+func newRecordingRules(cfg *RecordingConfig) []*recordingRule {
+	if cfg == nil {
+		return nil
+	}
+	rules := make([]*recordingRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		// Validated with the config.
+		expr, _ := parsePromQL(r.Expr)
+		rules = append(rules, &recordingRule{RecordingRuleConfig: r, expr: expr})
+	}
+	return rules
+}
+
+// sampleStore keeps the samples of the last flushes of one tenant in memory,
+// for the recording rules.
+type sampleStore struct {
+	series   map[string]*storedSeries // by metric name and labels
+	capacity int                      // samples kept per series
+}
+
+// storedSeries is a flushed series and its latest samples.
+type storedSeries struct {
+	name   string
+	labels map[string]string
+	delta  bool // the samples are deltas rather than cumulative
+	ring   sampleRing
+}
+
+func newSampleStore(retention time.Duration) *sampleStore {
+	return &sampleStore{
+		series:   make(map[string]*storedSeries),
+		capacity: int(retention/flushInterval) + 1,
+	}
+}
+
+func (s *sampleStore) add(name string, labels map[string]string, delta bool, sample promSample) {
+	key := name + "{" + seriesKey(labels) + "}"
+	ss, ok := s.series[key]
+	if !ok {
+		ss = &storedSeries{name: name, labels: labels, ring: sampleRing{buf: make([]promSample, s.capacity)}}
+		s.series[key] = ss
+	}
+	ss.delta = delta
+	ss.ring.push(sample)
+}
+
+// expire forgets the series that weren't flushed within retention.
+func (s *sampleStore) expire(now time.Time, retention time.Duration) {
+	for key, ss := range s.series {
+		if last, ok := ss.ring.last(); !ok || now.Sub(last.t) > retention {
+			delete(s.series, key)
+		}
+	}
+}
+
+// selectSeries returns the series a selector matches.
+func (s *sampleStore) selectSeries(sel *promSelector) []*storedSeries {
+	var out []*storedSeries
+	for _, key := range sortedKeys(s.series) {
+		ss := s.series[key]
+		if ss.name != sel.name {
+			continue
+		}
+		matched := true
+		for i := range sel.matchers {
+			if !sel.matchers[i].matches(ss.labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			out = append(out, ss)
+		}
+	}
+	return out
+}
+
+// ingest stores the samples of the metrics a tenant flushes. Labels are the
+// resource and data point attributes, without the collector identity and
+// the tenant.
+func (s *sampleStore) ingest(out *output, tenant string, now time.Time) {
+	rms := out.md.ResourceMetrics()
+	for i, origin := range out.origins {
+		if origin.tenant != tenant {
+			continue
+		}
+		for _, sm := range rms.At(i).ScopeMetrics().All() {
+			s.ingestMetrics(sm.Metrics(), origin.resource, now)
+		}
+	}
+}
+
+func (s *sampleStore) ingestMetrics(ms pmetric.MetricSlice, resource map[string]string, now time.Time) {
+	for _, m := range ms.All() {
+		switch m.Type() {
+		case pmetric.MetricTypeGauge:
+			s.ingestNumbers(m.Name(), resource, m.Gauge().DataPoints(), false, now)
+		case pmetric.MetricTypeSum:
+			delta := m.Sum().AggregationTemporality() == pmetric.AggregationTemporalityDelta
+			s.ingestNumbers(m.Name(), resource, m.Sum().DataPoints(), delta, now)
+		case pmetric.MetricTypeExponentialHistogram:
+			delta := m.ExponentialHistogram().AggregationTemporality() == pmetric.AggregationTemporalityDelta
+			dps := m.ExponentialHistogram().DataPoints()
+			for k := 0; k < dps.Len(); k++ {
+				dp := dps.At(k)
+				s.add(m.Name(), sampleLabels(resource, dp.Attributes()), delta,
+					promSample{t: now, h: histogramFromPoint(dp)})
+			}
+		}
+	}
+}
+
+func (s *sampleStore) ingestNumbers(name string, resource map[string]string, dps pmetric.NumberDataPointSlice, delta bool, now time.Time) {
+	for k := 0; k < dps.Len(); k++ {
+		dp := dps.At(k)
+		s.add(name, sampleLabels(resource, dp.Attributes()), delta, promSample{t: now, f: numberValue(dp)})
+	}
+}
+
+func sampleLabels(resource map[string]string, attrs pcommon.Map) map[string]string {
+	labels := make(map[string]string, len(resource)+attrs.Len())
+	for k, v := range resource {
+		labels[k] = v
+	}
+	for k, v := range attrs.All() {
+		labels[k] = v.AsString()
+	}
+	return labels
+}
+
+func histogramFromPoint(dp pmetric.ExponentialHistogramDataPoint) *promHistogram {
+	h := newPromHistogram(dp.Scale())
+	h.count, h.sum, h.zero = float64(dp.Count()), dp.Sum(), float64(dp.ZeroCount())
+	for _, b := range []struct {
+		buckets pmetric.ExponentialHistogramDataPointBuckets
+		counts  map[int32]float64
+	}{{dp.Positive(), h.positive}, {dp.Negative(), h.negative}} {
+		for i, c := range b.buckets.BucketCounts().All() {
+			if c > 0 {
+				b.counts[b.buckets.Offset()+int32(i)] = float64(c)
+			}
+		}
+	}
+	return h
+}
+
+// sampleRing holds the latest samples of a series, oldest first.
+type sampleRing struct {
+	buf      []promSample
+	start, n int
+}
+
+func (r *sampleRing) push(s promSample) {
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = s
+		r.n++
+		return
+	}
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+func (r *sampleRing) last() (promSample, bool) {
+	if r.n == 0 {
+		return promSample{}, false
+	}
+	return r.buf[(r.start+r.n-1)%len(r.buf)], true
+}
+
+// since returns the samples after t, oldest first.
+func (r *sampleRing) since(t time.Time) []promSample {
+	var out []promSample
+	for i := 0; i < r.n; i++ {
+		if s := r.buf[(r.start+i)%len(r.buf)]; s.t.After(t) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// recordLocked stores what every tenant flushes, evaluates the recording
+// rules against it and adds the results to out as gauges. Results are stored
+// too, so later rules can use them. Histogram results are only stored.
+func (p *simpleProcessor) recordLocked(out *output, now time.Time) {
+	retention := p.cfg.Recording.retention()
+	for _, tenant := range sortedKeys(p.tenants) {
+		t := p.tenants[tenant]
+		if t.samples == nil {
+			t.samples = newSampleStore(retention)
+		}
+		t.samples.ingest(out, tenant, now)
+		t.samples.expire(now, retention)
+
+		eval := &promEvaluator{store: t.samples, now: now, lookback: min(recordingLookback, retention)}
+		ts := pcommon.NewTimestampFromTime(now)
+		for _, r := range p.recording {
+			var dps pmetric.NumberDataPointSlice
+			created := false
+			for _, s := range eval.eval(r.expr) {
+				t.samples.add(r.Record, s.labels, false, s.promSample)
+				if s.h != nil {
+					continue
+				}
+				if !created {
+					m := out.scope(tenant, nil).Metrics().AppendEmpty()
+					m.SetName(r.Record)
+					dps = m.SetEmptyGauge().DataPoints()
+					created = true
+				}
+				dp := dps.AppendEmpty()
+				putAttributes(dp.Attributes(), s.labels)
+				dp.SetTimestamp(ts)
+				dp.SetDoubleValue(s.f)
+			}
+		}
+	}
+}
@@ -0,0 +1,614 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Aggregations a rule can use.
+const (
+	// AggregationSum adds up data point values into a cumulative counter.
+	AggregationSum = "sum"
+	// AggregationDistinctCount estimates the number of distinct values of an
+	// attribute with a HyperLogLog sketch.
+	AggregationDistinctCount = "distinct_count"
+	// AggregationQuantile sketches the distribution of data point values
+	// with a DDSketch.
+	AggregationQuantile = "quantile"
+	// AggregationTopK sums counters per value of a high-cardinality attribute
+	// for the heaviest values only, folding the rest into one series.
+	AggregationTopK = "top_k"
+)
+
+// Sources a rule can aggregate.
+const (
+	// SourceMetrics aggregates the data points of counters and gauges.
+	SourceMetrics = "metrics"
+	// SourceSpans aggregates spans, each turned into one data point.
+	SourceSpans = "spans"
+	// SourceLogs aggregates log records, each turned into one data point.
+	SourceLogs = "logs"
+)
+
+// RuleConfig defines one aggregation. Every rule keeps its own series and is
+// flushed as a metric named after the rule.
+type RuleConfig struct {
+	// Name of the rule and of the metric it is flushed as.
+	Name string `mapstructure:"name"`
+
+	// Source is metrics (default), spans or logs.
+	Source string `mapstructure:"source"`
+
+	// Metric restricts the rule to input metrics with this name. Empty
+	// matches every metric.
+	Metric string `mapstructure:"metric"`
+
+	// Span configures which spans a spans rule aggregates.
+	Span SpanConfig `mapstructure:"span"`
+
+	// Log configures which log records a logs rule aggregates.
+	Log LogConfig `mapstructure:"log"`
+
+	// GroupBy lists the data point attributes series are keyed by. Data
+	// points missing any of them are dropped.
+	GroupBy []string `mapstructure:"group_by"`
+
+	// ResourceAttributes lists the resource attributes series are keyed by,
+	// e.g. service.name. They are kept on the resource of the flushed
+	// metric. Missing ones are left out of the key.
+	ResourceAttributes []string `mapstructure:"resource_attributes"`
+
+	// Aggregation is one of sum (default), distinct_count, quantile or top_k.
+	Aggregation string `mapstructure:"aggregation"`
+
+	// DistinctCount configures the distinct_count aggregation.
+	DistinctCount DistinctCountConfig `mapstructure:"distinct_count"`
+
+	// Quantile configures the quantile aggregation.
+	Quantile QuantileConfig `mapstructure:"quantile"`
+
+	// TopK configures the top_k aggregation.
+	TopK TopKConfig `mapstructure:"top_k"`
+
+	// Window aggregates over tumbling or sliding windows instead of the
+	// lifetime of a series.
+	Window WindowConfig `mapstructure:"window"`
+
+	// UniqueBy counts every value of an attribute at most once per group.
+	UniqueBy UniqueByConfig `mapstructure:"unique_by"`
+
+	// Unit converts the data points of a metrics rule to one unit, so the
+	// same counter sent in ms and in s adds up.
+	Unit UnitConfig `mapstructure:"unit"`
+
+	// Validation rejects invalid data points instead of aggregating them.
+	Validation ValidationConfig `mapstructure:"validation"`
+}
+
+// defaultRules reproduce the original behavior: sum every counter by work.type.
+func defaultRules() []RuleConfig {
+	return []RuleConfig{{
+		Name:        defaultRule,
+		GroupBy:     []string{"work.type"},
+		Aggregation: AggregationSum,
+	}}
+}
+
+func (r *RuleConfig) source() string {
+	if r.Source == "" {
+		return SourceMetrics
+	}
+	return r.Source
+}
+
+func (r *RuleConfig) aggregation() string {
+	if r.Aggregation == "" {
+		return AggregationSum
+	}
+	return r.Aggregation
+}
+
+// Validate checks the rule.
+func (r *RuleConfig) Validate() error {
+	if r.Name == "" {
+		return errors.New("name must be set")
+	}
+	if _, ok := exprFunctions[r.Name]; ok {
+		return fmt.Errorf("name %q is a function of derived expressions", r.Name)
+	}
+	switch r.aggregation() {
+	case AggregationSum:
+	case AggregationDistinctCount:
+		if err := r.DistinctCount.Validate(); err != nil {
+			return fmt.Errorf("distinct_count: %w", err)
+		}
+	case AggregationQuantile:
+		if err := r.Quantile.Validate(); err != nil {
+			return fmt.Errorf("quantile: %w", err)
+		}
+	case AggregationTopK:
+		if err := r.TopK.Validate(); err != nil {
+			return fmt.Errorf("top_k: %w", err)
+		}
+		for _, key := range r.GroupBy {
+			if key == r.TopK.Attribute {
+				return errors.New("top_k: attribute must not be in group_by")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown aggregation %q", r.Aggregation)
+	}
+
+	switch r.source() {
+	case SourceMetrics:
+	case SourceSpans:
+		if r.Metric != "" {
+			return errors.New("metric must not be set for spans rules")
+		}
+		if err := r.Span.Validate(); err != nil {
+			return fmt.Errorf("span: %w", err)
+		}
+		if r.Span.value() == SpanValueDuration && r.aggregation() != AggregationQuantile {
+			return errors.New("span: duration needs the quantile aggregation")
+		}
+	case SourceLogs:
+		if r.Metric != "" {
+			return errors.New("metric must not be set for logs rules")
+		}
+		if err := r.Log.Validate(); err != nil {
+			return fmt.Errorf("log: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown source %q", r.Source)
+	}
+
+	if err := r.Window.Validate(); err != nil {
+		return fmt.Errorf("window: %w", err)
+	}
+	if err := r.UniqueBy.Validate(); err != nil {
+		return fmt.Errorf("unique_by: %w", err)
+	}
+	if err := r.Unit.Validate(); err != nil {
+		return fmt.Errorf("unit: %w", err)
+	}
+	if err := r.Validation.Validate(); err != nil {
+		return fmt.Errorf("validation: %w", err)
+	}
+	if r.Unit.enabled() && r.source() != SourceMetrics {
+		return errors.New("unit: only metrics rules can convert units")
+	}
+	if r.Unit.mismatch() == UnitMismatchSeparate && slices.Contains(r.GroupBy, unitAttribute) {
+		return fmt.Errorf("unit: %q must not be in group_by with the separate mismatch", unitAttribute)
+	}
+	if r.UniqueBy.enabled() && slices.Contains(r.GroupBy, r.UniqueBy.Attribute) {
+		return errors.New("unique_by: attribute must not be in group_by")
+	}
+	if r.Window.enabled() && r.aggregation() == AggregationTopK {
+		return errors.New("window: top_k rules can't be windowed")
+	}
+	if r.Window.Type == WindowSliding && r.aggregation() == AggregationQuantile &&
+		r.Quantile.output() == QuantileOutputExponentialHistogram {
+		return errors.New("window: sliding windows can't be flushed as exponential histograms")
+	}
+	return nil
+}
+
+func validateRules(rules []RuleConfig) error {
+	names := make(map[string]struct{}, len(rules))
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		if _, ok := names[rules[i].Name]; ok {
+			return fmt.Errorf("rules[%d]: duplicate rule name %q", i, rules[i].Name)
+		}
+		names[rules[i].Name] = struct{}{}
+	}
+	return nil
+}
+
+// rule is a RuleConfig ready to aggregate data points.
+type rule struct {
+	RuleConfig
+
+	bodyPattern *regexp.Regexp // nil unless a logs rule has a body pattern
+	target      *ucumUnit      // nil unless the rule converts units
+}
+
+func newRules(cfgs []RuleConfig) []*rule {
+	if len(cfgs) == 0 {
+		cfgs = defaultRules()
+	}
+	rules := make([]*rule, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		r := &rule{RuleConfig: cfg}
+		if cfg.source() == SourceLogs && cfg.Log.BodyPattern != "" {
+			// Validated with the config.
+			r.bodyPattern = regexp.MustCompile(cfg.Log.BodyPattern)
+		}
+		if cfg.Unit.enabled() {
+			// Validated with the config.
+			r.target, _ = parseUnit(cfg.Unit.Target)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// matches reports whether the rule aggregates data points of metric.
+func (r *rule) matches(metric pmetric.Metric) bool {
+	if r.source() != SourceMetrics {
+		return false
+	}
+	if r.Metric != "" && r.Metric != metric.Name() {
+		return false
+	}
+	switch metric.Type() {
+	case pmetric.MetricTypeSum:
+		return true
+	case pmetric.MetricTypeGauge:
+		return r.aggregation() == AggregationDistinctCount || r.aggregation() == AggregationQuantile
+	default:
+		return false
+	}
+}
+
+// groupAttributes extracts the attributes a data point is grouped by. It
+// returns false when any of them is missing.
+func (r *rule) groupAttributes(attrs pcommon.Map) (map[string]string, bool) {
+	group := make(map[string]string, len(r.GroupBy))
+	for _, key := range r.GroupBy {
+		v, ok := attrs.Get(key)
+		if !ok {
+			return nil, false
+		}
+		group[key] = v.AsString()
+	}
+	if r.Unit.enabled() && r.Unit.mismatch() == UnitMismatchSeparate {
+		// Set on data points whose unit couldn't be converted.
+		if v, ok := attrs.Get(unitAttribute); ok {
+			group[unitAttribute] = v.AsString()
+		}
+	}
+	return group, true
+}
+
+// resourceAttributes extracts the resource attributes a data point is grouped
+// by. It returns nil when there are none.
+func (r *rule) resourceAttributes(attrs pcommon.Map) map[string]string {
+	var resource map[string]string
+	for _, key := range r.ResourceAttributes {
+		v, ok := attrs.Get(key)
+		if !ok {
+			continue
+		}
+		if resource == nil {
+			resource = make(map[string]string, len(r.ResourceAttributes))
+		}
+		resource[key] = v.AsString()
+	}
+	return resource
+}
+
+// add folds a data point into a series, or into the pane of its window for
+// windowed rules. It returns how a late data point was handled, and the
+// reason the data point was dropped or an empty string when it was
+// aggregated.
+func (r *rule) add(state *ruleState, s *series, dp pmetric.NumberDataPoint, now time.Time) (handling, reason string) {
+	if !r.Window.enabled() {
+		if reason := r.unique(s, dp, now); reason != "" {
+			return "", reason
+		}
+		if reason := r.addTo(&s.aggregate, dp); reason != "" {
+			return "", reason
+		}
+		r.rememberUnique(s, dp, now)
+		return "", ""
+	}
+
+	ts := eventTime(dp, now)
+	if ts.After(state.MaxEventTime) {
+		state.MaxEventTime = ts
+	}
+	pn, handling := r.paneFor(state, s, ts, now)
+	if pn == nil {
+		return handling, dropLate
+	}
+	if reason := r.unique(s, dp, now); reason != "" {
+		return handling, reason
+	}
+	if reason = r.addTo(&pn.aggregate, dp); reason != "" {
+		return handling, reason
+	}
+	r.rememberUnique(s, dp, now)
+	if pn.Late == nil {
+		return handling, ""
+	}
+	return handling, r.addTo(pn.Late, dp)
+}
+
+// unique returns the reason a data point is dropped by unique_by, or an
+// empty string when its value wasn't counted before. The value is only
+// remembered by rememberUnique, once the data point is aggregated, so one
+// that is rejected counts when it is sent again.
+func (r *rule) unique(s *series, dp pmetric.NumberDataPoint, now time.Time) string {
+	if !r.UniqueBy.enabled() {
+		return ""
+	}
+	v, ok := dp.Attributes().Get(r.UniqueBy.Attribute)
+	if !ok {
+		return dropMissingGroupKey
+	}
+	if s.Unique != nil && s.Unique.contains(&r.UniqueBy, v.AsString(), now) {
+		return dropNotUnique
+	}
+	return ""
+}
+
+// rememberUnique remembers the unique_by value of an aggregated data point.
+func (r *rule) rememberUnique(s *series, dp pmetric.NumberDataPoint, now time.Time) {
+	if !r.UniqueBy.enabled() {
+		return
+	}
+	v, ok := dp.Attributes().Get(r.UniqueBy.Attribute)
+	if !ok {
+		return
+	}
+	if s.Unique == nil {
+		s.Unique = &uniqueFilter{}
+	}
+	s.Unique.add(&r.UniqueBy, v.AsString(), now)
+}
+
+// addTo folds a data point into an aggregate. It returns the reason the data
+// point was dropped, or an empty string when it was aggregated.
+func (r *rule) addTo(agg *aggregate, dp pmetric.NumberDataPoint) string {
+	switch r.aggregation() {
+	case AggregationDistinctCount:
+		v, ok := dp.Attributes().Get(r.DistinctCount.Attribute)
+		if !ok {
+			return dropMissingGroupKey
+		}
+		if agg.Distinct == nil {
+			agg.Distinct = newHLLSketch(r.DistinctCount.Precision)
+		}
+		agg.Distinct.insert(v.AsString())
+	case AggregationQuantile:
+		if agg.Quantile == nil {
+			agg.Quantile = newDDSketch(r.Quantile.scale())
+		}
+		if err := agg.Quantile.add(numberValue(dp)); err != nil {
+			return dropInvalidValue
+		}
+		agg.Value++
+	case AggregationTopK:
+		v, ok := dp.Attributes().Get(r.TopK.Attribute)
+		if !ok {
+			return dropMissingGroupKey
+		}
+		if numberValue(dp) < 0 {
+			return dropInvalidValue
+		}
+		if agg.TopK == nil {
+			agg.TopK = newTopKSketch(r.TopK.capacity())
+		}
+		agg.TopK.add(v.AsString(), agg.add(dp))
+	default:
+		agg.add(dp)
+	}
+	return ""
+}
+
+// unit returns the unit the rule is flushed with.
+func (r *rule) unit() string {
+	if r.Unit.enabled() {
+		return r.Unit.Target
+	}
+	if r.source() == SourceSpans && r.Span.value() == SpanValueDuration {
+		return "ms"
+	}
+	return "1"
+}
+
+// appendMetric adds the rule's series to out as one metric per resource.
+// Lifetime rules flush every series, windowed rules the windows that closed
+// or were corrected since the last flush. Nothing is added when there is
+// nothing to flush.
+func (r *rule) appendMetric(out *output, tenant string, state *ruleState, now time.Time) error {
+	type resourceMetric struct {
+		resource map[string]string
+		metric   pmetric.Metric
+	}
+	metrics := make(map[string]resourceMetric)
+	ts := pcommon.NewTimestampFromTime(now)
+	watermark := r.Window.watermark(state, now)
+
+	var errs []error
+	for _, s := range state.Series {
+		resourceKey := seriesKey(s.Resource)
+		rmetric, ok := metrics[resourceKey]
+		if !ok {
+			rmetric = resourceMetric{resource: s.Resource, metric: pmetric.NewMetric()}
+			rmetric.metric.SetName(r.Name)
+			rmetric.metric.SetUnit(r.unit())
+			r.initMetric(rmetric.metric)
+			metrics[resourceKey] = rmetric
+		}
+		m := rmetric.metric
+
+		if !r.Window.enabled() {
+			var start pcommon.Timestamp
+			if r.cumulative() {
+				start = pcommon.NewTimestampFromTime(s.StartTime)
+			}
+			r.appendPoints(m, s.Attributes, &s.aggregate, start, ts)
+			continue
+		}
+		windows, err := r.closeWindows(s, watermark)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, w := range windows {
+			r.appendPoints(m, s.Attributes, w.agg,
+				pcommon.NewTimestampFromTime(w.start), pcommon.NewTimestampFromTime(w.end))
+		}
+	}
+
+	for _, rmetric := range metrics {
+		if dataPointCount(rmetric.metric) > 0 {
+			rmetric.metric.MoveTo(out.scope(tenant, rmetric.resource).Metrics().AppendEmpty())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cumulative reports whether a lifetime rule is flushed with start times.
+func (r *rule) cumulative() bool {
+	return r.aggregation() != AggregationDistinctCount &&
+		(r.aggregation() != AggregationQuantile || r.Quantile.output() == QuantileOutputExponentialHistogram)
+}
+
+// delta reports whether the windows of the rule are flushed as deltas, which
+// add up rather than replace each other.
+func (r *rule) delta() bool {
+	if r.Window.Type != WindowTumbling {
+		return false
+	}
+	return r.aggregation() == AggregationSum ||
+		(r.aggregation() == AggregationQuantile && r.Quantile.output() == QuantileOutputExponentialHistogram)
+}
+
+// initMetric sets the type of the rule's metric. Lifetime rules are
+// cumulative, tumbling windows are deltas and sliding windows, which overlap,
+// are gauges.
+func (r *rule) initMetric(m pmetric.Metric) {
+	temporality := pmetric.AggregationTemporalityCumulative
+	if r.Window.Type == WindowTumbling {
+		temporality = pmetric.AggregationTemporalityDelta
+	}
+
+	switch r.aggregation() {
+	case AggregationDistinctCount:
+		m.SetEmptyGauge()
+	case AggregationQuantile:
+		if r.Quantile.output() == QuantileOutputExponentialHistogram {
+			m.SetEmptyExponentialHistogram().SetAggregationTemporality(temporality)
+		} else {
+			m.SetEmptyGauge()
+		}
+	case AggregationTopK:
+		// Values move in and out of the top K, so the sum is not monotonic.
+		sum := m.SetEmptySum()
+		sum.SetAggregationTemporality(temporality)
+	default:
+		if r.Window.Type == WindowSliding {
+			m.SetEmptyGauge()
+			return
+		}
+		sum := m.SetEmptySum()
+		sum.SetIsMonotonic(true)
+		sum.SetAggregationTemporality(temporality)
+	}
+}
+
+// appendPoints adds the data points of one aggregate to m. A zero start
+// leaves the start timestamp unset.
+func (r *rule) appendPoints(m pmetric.Metric, attrs map[string]string, agg *aggregate, start, ts pcommon.Timestamp) {
+	switch r.aggregation() {
+	case AggregationDistinctCount:
+		if agg.Distinct != nil {
+			agg.Value = int64(agg.Distinct.estimate())
+		}
+		dp := numberDataPoints(m).AppendEmpty()
+		putAttributes(dp.Attributes(), attrs)
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(ts)
+		dp.SetIntValue(agg.Value)
+	case AggregationQuantile:
+		r.appendQuantiles(m, attrs, agg, start, ts)
+	case AggregationTopK:
+		r.appendTopK(m, attrs, agg, start, ts)
+	default:
+		dp := numberDataPoints(m).AppendEmpty()
+		putAttributes(dp.Attributes(), attrs)
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(ts)
+		dp.SetIntValue(agg.Value)
+	}
+}
+
+// appendQuantiles flushes a quantile sketch in its configured output.
+func (r *rule) appendQuantiles(m pmetric.Metric, attrs map[string]string, agg *aggregate, start, ts pcommon.Timestamp) {
+	if r.Quantile.output() == QuantileOutputExponentialHistogram {
+		dp := m.ExponentialHistogram().DataPoints().AppendEmpty()
+		putAttributes(dp.Attributes(), attrs)
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(ts)
+		if agg.Quantile != nil {
+			agg.Quantile.copyTo(dp)
+		}
+		return
+	}
+
+	if agg.Quantile == nil || agg.Quantile.count() == 0 {
+		return
+	}
+	qs := r.Quantile.quantiles()
+	values, err := agg.Quantile.quantiles(qs)
+	if err != nil {
+		return
+	}
+	for i, q := range qs {
+		dp := m.Gauge().DataPoints().AppendEmpty()
+		putAttributes(dp.Attributes(), attrs)
+		dp.Attributes().PutDouble("quantile", q)
+		dp.SetStartTimestamp(start)
+		dp.SetTimestamp(ts)
+		dp.SetDoubleValue(values[i])
+	}
+}
+
+// appendTopK flushes the K heaviest values of a group as their own series
+// and the rest of the group's total as the other value.
+func (r *rule) appendTopK(m pmetric.Metric, attrs map[string]string, agg *aggregate, start, ts pcommon.Timestamp) {
+	dps := m.Sum().DataPoints()
+	other := agg.Value
+	if agg.TopK != nil {
+		for _, c := range agg.TopK.top(r.TopK.K) {
+			dp := dps.AppendEmpty()
+			putAttributes(dp.Attributes(), attrs)
+			dp.Attributes().PutStr(r.TopK.Attribute, c.Value)
+			dp.SetStartTimestamp(start)
+			dp.SetTimestamp(ts)
+			dp.SetIntValue(c.Count)
+			other -= c.Count
+		}
+	}
+	dp := dps.AppendEmpty()
+	putAttributes(dp.Attributes(), attrs)
+	dp.Attributes().PutStr(r.TopK.Attribute, r.TopK.otherValue())
+	dp.SetStartTimestamp(start)
+	dp.SetTimestamp(ts)
+	// Counts are overestimates, so the rest can come out negative.
+	dp.SetIntValue(max(other, 0))
+}
+
+func putAttributes(dst pcommon.Map, attrs map[string]string) {
+	for k, v := range attrs {
+		dst.PutStr(k, v)
+	}
+}
+
+// reset starts a series over.
+func (r *rule) reset(s *series, now time.Time) {
+	s.aggregate = aggregate{}
+	s.StartTime = now
+	s.Panes = nil
+	s.Unique = nil
+}
@@ -0,0 +1,249 @@
+package simpleprocessor
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor"
+)
+
+// processors holds the processor of every config. A processor in several
+// pipelines, e.g. a metrics and a traces pipeline, is one instance with one
+// state, checkpoint and flush loop.
+var processors = &sharedProcessors{byConfig: make(map[*Config]*sharedProcessor)}
+
+type sharedProcessors struct {
+	mu       sync.Mutex
+	byConfig map[*Config]*sharedProcessor
+}
+
+// sharedProcessor is a processor and the pipelines it is in.
+type sharedProcessor struct {
+	*simpleProcessor
+
+	// metrics are the next consumers of the metrics pipelines, flushes go to
+	// all of them.
+	metrics metricsFanout
+	// logs are the next consumers of the logs pipelines a connector is a
+	// receiver of, alerts go to all of them.
+	logs    logsFanout
+	refs    int // pipelines the processor was created for and not shut down yet
+	started bool
+
+	// claimed lists the input signals a connector instance aggregates. The
+	// collector creates a connector instance per input and output pipeline
+	// type and passes the input to every one of them, only the first may
+	// aggregate it.
+	claimed map[string]bool
+}
+
+// get returns the processor or connector of cfg, creating it for the first
+// pipeline. metrics and logs are the next consumers of a connector's metrics
+// or logs pipelines, or of a processor's metrics pipeline, and nil otherwise.
+func (s *sharedProcessors) get(set processor.Settings, kind component.Kind, cfg *Config, metrics consumer.Metrics, logs consumer.Logs) (*sharedProcessor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sp, ok := s.byConfig[cfg]
+	if !ok {
+		p, err := newProcessor(set, nil, cfg)
+		if err != nil {
+			return nil, err
+		}
+		p.kind = kind
+		sp = &sharedProcessor{simpleProcessor: p, claimed: make(map[string]bool)}
+		p.next = &sp.metrics
+		s.byConfig[cfg] = sp
+	}
+	sp.refs++
+	switch {
+	case metrics == nil:
+	case kind == component.KindConnector:
+		// Every connector instance gets a consumer for the same metrics
+		// pipelines, flushing to more than one would duplicate the flushes.
+		sp.metrics = metricsFanout{metrics}
+	default:
+		sp.metrics = append(sp.metrics, metrics)
+	}
+	if logs != nil {
+		sp.logs = logsFanout{logs}
+		sp.logsOut = &sp.logs
+	}
+	return sp, nil
+}
+
+// claim reports whether a new connector instance for the input signal is the
+// one that aggregates it.
+func (s *sharedProcessors) claim(sp *sharedProcessor, signal string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sp.claimed[signal] {
+		return false
+	}
+	sp.claimed[signal] = true
+	return true
+}
+
+// start starts the processor in the first pipeline that starts.
+func (s *sharedProcessors) start(ctx context.Context, sp *sharedProcessor, host component.Host) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sp.started {
+		return nil
+	}
+	sp.started = true
+	if len(sp.metrics) == 0 && sp.kind == component.KindProcessor {
+		sp.logger.Warn("The processor is in no metrics pipeline, its aggregations are checkpointed but not flushed anywhere")
+	}
+	if len(sp.alerts) > 0 && len(sp.logs) == 0 {
+		sp.logger.Warn("Alerts are only logged, make the connector a receiver of a logs pipeline to emit them")
+	}
+	return sp.simpleProcessor.Start(ctx, host)
+}
+
+// shutdown shuts the processor down with the last pipeline.
+func (s *sharedProcessors) shutdown(ctx context.Context, sp *sharedProcessor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sp.refs--
+	if sp.refs > 0 {
+		return nil
+	}
+	for cfg, other := range s.byConfig {
+		if other == sp {
+			delete(s.byConfig, cfg)
+		}
+	}
+	return sp.simpleProcessor.Shutdown(ctx)
+}
+
+// metricsProcessor is the processor in a metrics pipeline.
+type metricsProcessor struct {
+	*sharedProcessor
+	shadow bool // another connector instance aggregates the metrics
+}
+
+func (m metricsProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	if m.shadow {
+		return nil
+	}
+	return m.sharedProcessor.ConsumeMetrics(ctx, md)
+}
+
+func (m metricsProcessor) Start(ctx context.Context, host component.Host) error {
+	return processors.start(ctx, m.sharedProcessor, host)
+}
+
+func (m metricsProcessor) Shutdown(ctx context.Context) error {
+	return processors.shutdown(ctx, m.sharedProcessor)
+}
+
+// tracesProcessor is the processor in a traces pipeline. It aggregates spans
+// with the spans rules and passes them on unchanged.
+type tracesProcessor struct {
+	*sharedProcessor
+	next   consumer.Traces
+	shadow bool // another connector instance aggregates the spans
+}
+
+func (t tracesProcessor) Start(ctx context.Context, host component.Host) error {
+	return processors.start(ctx, t.sharedProcessor, host)
+}
+
+func (t tracesProcessor) Shutdown(ctx context.Context) error {
+	return processors.shutdown(ctx, t.sharedProcessor)
+}
+
+func (t tracesProcessor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+func (t tracesProcessor) ConsumeTraces(ctx context.Context, td ptrace.Traces) error {
+	if !t.shadow {
+		t.consumeSpans(ctx, td)
+	}
+	if t.next == nil {
+		// A connector ends the traces pipeline.
+		return nil
+	}
+	return t.next.ConsumeTraces(ctx, td)
+}
+
+// logsProcessor is the processor in a logs pipeline. It aggregates log
+// records with the logs rules and passes them on unchanged.
+type logsProcessor struct {
+	*sharedProcessor
+	next   consumer.Logs
+	shadow bool // another connector instance aggregates the log records
+}
+
+func (l logsProcessor) Start(ctx context.Context, host component.Host) error {
+	return processors.start(ctx, l.sharedProcessor, host)
+}
+
+func (l logsProcessor) Shutdown(ctx context.Context) error {
+	return processors.shutdown(ctx, l.sharedProcessor)
+}
+
+func (l logsProcessor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+func (l logsProcessor) ConsumeLogs(ctx context.Context, ld plog.Logs) error {
+	if !l.shadow {
+		l.consumeLogs(ctx, ld)
+	}
+	if l.next == nil {
+		// A connector ends the logs pipeline.
+		return nil
+	}
+	return l.next.ConsumeLogs(ctx, ld)
+}
+
+// metricsFanout sends flushes to the next consumer of every metrics pipeline
+// the processor is in. Every consumer but the last gets a copy, since they
+// may mutate it.
+type metricsFanout []consumer.Metrics
+
+func (f *metricsFanout) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	var errs []error
+	for i, next := range *f {
+		data := md
+		if i < len(*f)-1 {
+			data = pmetric.NewMetrics()
+			md.CopyTo(data)
+		}
+		errs = append(errs, next.ConsumeMetrics(ctx, data))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *metricsFanout) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+// logsFanout sends alerts to the next consumer of every logs pipeline the
+// connector is a receiver of, copying like metricsFanout.
+type logsFanout []consumer.Logs
+
+func (f *logsFanout) ConsumeLogs(ctx context.Context, ld plog.Logs) error {
+	var errs []error
+	for i, next := range *f {
+		data := ld
+		if i < len(*f)-1 {
+			data = plog.NewLogs()
+			ld.CopyTo(data)
+		}
+		errs = append(errs, next.ConsumeLogs(ctx, data))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *logsFanout) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
@@ -0,0 +1,14 @@
+// Package simpleconnector provides the simple processor as a connector, for
+// the collector builder, which imports one factory per package.
+package simpleconnector
+
+import (
+	"go.opentelemetry.io/collector/connector"
+
+	simpleprocessor "github.com/myuser/simpleprocessor"
+)
+
+// NewFactory creates a factory for the simple connector.
+func NewFactory() connector.Factory {
+	return simpleprocessor.NewConnectorFactory()
+}
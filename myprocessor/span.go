@@ -0,0 +1,181 @@
+package simpleprocessor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// Values a span contributes to a span rule.
+const (
+	// SpanValueCount counts spans.
+	SpanValueCount = "count"
+	// SpanValueErrors counts spans with an error status. Other spans count
+	// as zero, so every group has an error count.
+	SpanValueErrors = "errors"
+	// SpanValueDuration is the span duration in milliseconds, for quantile
+	// rules.
+	SpanValueDuration = "duration"
+)
+
+// Attributes every span data point carries besides the span attributes, so
+// rules can group by them.
+const (
+	spanNameAttribute   = "span.name"
+	spanKindAttribute   = "span.kind"
+	statusCodeAttribute = "status.code"
+)
+
+var spanKinds = map[string]ptrace.SpanKind{
+	"internal": ptrace.SpanKindInternal,
+	"server":   ptrace.SpanKindServer,
+	"client":   ptrace.SpanKindClient,
+	"producer": ptrace.SpanKindProducer,
+	"consumer": ptrace.SpanKindConsumer,
+}
+
+// SpanConfig configures which spans a span rule aggregates and what each
+// contributes.
+type SpanConfig struct {
+	// Name restricts the rule to spans with this name. Empty matches every
+	// span.
+	Name string `mapstructure:"name"`
+
+	// Kind restricts the rule to spans of this kind: internal, server,
+	// client, producer or consumer.
+	Kind string `mapstructure:"kind"`
+
+	// Value is count (default), errors or duration.
+	Value string `mapstructure:"value"`
+}
+
+// Validate checks the configuration.
+func (c *SpanConfig) Validate() error {
+	if _, ok := spanKinds[c.Kind]; c.Kind != "" && !ok {
+		return errors.New("kind must be internal, server, client, producer or consumer")
+	}
+	switch c.Value {
+	case "", SpanValueCount, SpanValueErrors, SpanValueDuration:
+	default:
+		return errors.New("value must be count, errors or duration")
+	}
+	return nil
+}
+
+func (c *SpanConfig) value() string {
+	if c.Value == "" {
+		return SpanValueCount
+	}
+	return c.Value
+}
+
+// matchesSpan reports whether the rule aggregates span.
+func (r *rule) matchesSpan(span ptrace.Span) bool {
+	if r.source() != SourceSpans {
+		return false
+	}
+	if r.Span.Name != "" && r.Span.Name != span.Name() {
+		return false
+	}
+	return r.Span.Kind == "" || spanKinds[r.Span.Kind] == span.Kind()
+}
+
+// setSpanValue sets the value span contributes to the rule on dp.
+func (r *rule) setSpanValue(dp pmetric.NumberDataPoint, span ptrace.Span) {
+	switch r.Span.value() {
+	case SpanValueErrors:
+		if span.Status().Code() == ptrace.StatusCodeError {
+			dp.SetIntValue(1)
+		} else {
+			dp.SetIntValue(0)
+		}
+	case SpanValueDuration:
+		dp.SetDoubleValue(float64(span.EndTimestamp()-span.StartTimestamp()) / 1e6)
+	default:
+		dp.SetIntValue(1)
+	}
+}
+
+// spanDataPoint fills dp with the attributes and timestamps of span. The
+// value is set per rule by setSpanValue.
+func spanDataPoint(dp pmetric.NumberDataPoint, span ptrace.Span) {
+	attrs := dp.Attributes()
+	attrs.Clear()
+	span.Attributes().CopyTo(attrs)
+	for k, v := range map[string]string{
+		spanNameAttribute:   span.Name(),
+		spanKindAttribute:   strings.ToLower(span.Kind().String()),
+		statusCodeAttribute: strings.ToLower(span.Status().Code().String()),
+	} {
+		if _, ok := attrs.Get(k); !ok {
+			attrs.PutStr(k, v)
+		}
+	}
+	dp.SetStartTimestamp(span.StartTimestamp())
+	dp.SetTimestamp(span.EndTimestamp())
+}
+
+// spanHash identifies a span by its resource attributes, trace and span ID.
+func spanHash(resource pcommon.Resource, span ptrace.Span) uint64 {
+	h := fnv.New64a()
+	hashMap(h, resource.Attributes())
+	traceID, spanID := span.TraceID(), span.SpanID()
+	h.Write(traceID[:])
+	h.Write(spanID[:])
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(span.StartTimestamp()))
+	h.Write(buf[:])
+	return h.Sum64()
+}
+
+// consumeSpans aggregates the spans of td with the spans rules.
+func (p *simpleProcessor) consumeSpans(ctx context.Context, td ptrace.Traces) {
+	if !slices.ContainsFunc(p.rules, func(r *rule) bool { return r.source() == SourceSpans }) {
+		return
+	}
+	counts := newConsumeCounts()
+	defer counts.record(ctx, p.telemetry)
+	defer p.sendQuarantine(ctx, counts)
+
+	p.lock(ctx, opConsume)
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	info := client.FromContext(ctx)
+	dp := pmetric.NewNumberDataPoint()
+	var matched []*rule
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			spans := rs.ScopeSpans().At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				matched = matched[:0]
+				for _, r := range p.rules {
+					if r.matchesSpan(span) {
+						matched = append(matched, r)
+					}
+				}
+				if len(matched) == 0 {
+					continue
+				}
+				spanDataPoint(dp, span)
+				hash := func() uint64 { return spanHash(rs.Resource(), span) }
+				prepare := func(i int) string {
+					matched[i].setSpanValue(dp, span)
+					return ""
+				}
+				p.aggregateLocked(counts, info, rs.Resource(), dp, hash, matched, prepare, now)
+			}
+		}
+	}
+}
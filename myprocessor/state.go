@@ -0,0 +1,334 @@
+package simpleprocessor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// checkpointVersion is the version of the checkpoint format written by this
+// processor. Older versions are still read:
+//
+//	1 the original flat map of work.type to count
+//	2 series per rule with their start and last-seen times
+//	3 adds sketches, windows, deduplication, unique_by filters, resources,
+//	  tenants and alert state
+//	4 adds the alert records that weren't delivered yet
+//	5 adds the previous values of rates of derived metrics
+//	6 adds the remainders of values that don't make a whole count
+//
+// An older processor would drop what it doesn't know on its next checkpoint,
+// so every addition to the format bumps the version and older processors
+// refuse to load it.
+const checkpointVersion = 6
+
+// aggregate is what a rule has aggregated, either over the lifetime of a
+// series or within one pane of a window.
+type aggregate struct {
+	Value int64 `json:"value"`
+
+	// Remainder is what Value was rounded by, so fractional values, e.g.
+	// doubles or converted units, add up before they're rounded.
+	Remainder float64 `json:"remainder,omitempty"`
+
+	// Distinct is the sketch of distinct_count rules.
+	Distinct *hllSketch `json:"distinct,omitempty"`
+
+	// Quantile is the sketch of quantile rules. Value counts its observations.
+	Quantile *ddSketch `json:"quantile,omitempty"`
+
+	// TopK is the sketch of top_k rules. Value is the total of the group.
+	TopK *topKSketch `json:"top_k,omitempty"`
+}
+
+// series is one aggregated time series of a rule.
+type series struct {
+	Attributes map[string]string `json:"attributes"`
+	Resource   map[string]string `json:"resource,omitempty"`
+	aggregate
+	StartTime time.Time `json:"start_time"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// Panes hold the open windows of windowed rules, keyed by the start of
+	// the pane in Unix nanoseconds.
+	Panes map[int64]*pane `json:"panes,omitempty"`
+
+	// Emitted is the end of the last window flushed. Panes that end before
+	// it are closed, data points for them are late.
+	Emitted time.Time `json:"emitted,omitzero"`
+
+	// Unique remembers the values of the unique_by attribute counted so far.
+	Unique *uniqueFilter `json:"unique,omitempty"`
+}
+
+// ruleState holds the series of one rule, keyed by seriesKey.
+type ruleState struct {
+	Series map[string]*series `json:"series"`
+
+	// MaxEventTime is the latest data point timestamp a windowed rule has
+	// seen. It drives event time watermarks.
+	MaxEventTime time.Time `json:"max_event_time,omitzero"`
+}
+
+func newRuleState() *ruleState {
+	return &ruleState{Series: make(map[string]*series)}
+}
+
+// checkpoint is the persisted aggregation state.
+type checkpoint struct {
+	Version int                   `json:"version"`
+	Rules   map[string]*ruleState `json:"rules"`
+
+	// Dedup holds the data points seen recently when deduplication is on.
+	Dedup *dedupSet `json:"dedup,omitempty"`
+
+	// Alerts holds which series each alert fires for, so alerts don't fire
+	// again after a restart.
+	Alerts map[string]*alertState `json:"alerts,omitempty"`
+
+	// Rates holds the previous values of the rates of derived metrics, so
+	// they carry on across restarts.
+	Rates map[string]map[string]alertSample `json:"rates,omitempty"`
+
+	// Tenants lists the tenants stored under their own keys. Only the main
+	// checkpoint has it.
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// checkpointTenants returns the tenants a main checkpoint lists, nil if it
+// can't be decoded.
+func checkpointTenants(data []byte) []string {
+	var probe struct {
+		Tenants []string `json:"tenants"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil
+	}
+	return probe.Tenants
+}
+
+// seriesKey identifies a series by its attributes, independent of their order.
+func seriesKey(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(attrs[k]))
+	}
+	return b.String()
+}
+
+// resourceSeriesKey identifies a series by its resource and data point
+// attributes. Series without resource attributes are keyed by seriesKey.
+func resourceSeriesKey(resource, attrs map[string]string) string {
+	if len(resource) == 0 {
+		return seriesKey(attrs)
+	}
+	return "{" + seriesKey(resource) + "}" + seriesKey(attrs)
+}
+
+// matches reports whether the series has every attribute in matchers, as a
+// data point or resource attribute.
+func (s *series) matches(matchers map[string]string) bool {
+	for k, v := range matchers {
+		got, ok := s.Attributes[k]
+		if !ok {
+			got = s.Resource[k]
+		}
+		if got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeCheckpoint serializes the aggregation state in the current version.
+func encodeCheckpoint(cp *checkpoint) ([]byte, error) {
+	cp.Version = checkpointVersion
+	return json.Marshal(cp)
+}
+
+// decodeCheckpoint parses a checkpoint of any known version. Older versions
+// are migrated, with now used as the start time of series that lack one.
+func decodeCheckpoint(data []byte, now time.Time) (*checkpoint, error) {
+	version, err := CheckpointVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Version 1 has no version field, it's a flat map of work.type to count.
+	if version == 1 {
+		var legacy map[string]int64
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, err
+		}
+		state := newRuleState()
+		for workType, count := range legacy {
+			attrs := map[string]string{"work.type": workType}
+			state.Series[seriesKey(attrs)] = &series{Attributes: attrs, aggregate: aggregate{Value: count}, StartTime: now}
+		}
+		return &checkpoint{Version: checkpointVersion, Rules: map[string]*ruleState{defaultRule: state}}, nil
+	}
+
+	if version < 1 || version > checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d, expected at most %d", version, checkpointVersion)
+	}
+	// Versions 2 to 5 are subsets of the current version, the fields they
+	// lack start out empty.
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	cp.Version = checkpointVersion
+	if cp.Rules == nil {
+		cp.Rules = make(map[string]*ruleState)
+	}
+	for name, state := range cp.Rules {
+		if state == nil || state.Series == nil {
+			return nil, fmt.Errorf("rule %q has no series", name)
+		}
+		for key, s := range state.Series {
+			if s == nil {
+				return nil, fmt.Errorf("rule %q has an empty series %q", name, key)
+			}
+			if s.StartTime.IsZero() {
+				s.StartTime = now
+			}
+			for start, pn := range s.Panes {
+				if pn == nil {
+					return nil, fmt.Errorf("rule %q series %q has an empty pane at %d", name, key, start)
+				}
+			}
+		}
+	}
+	for name, as := range cp.Alerts {
+		if as == nil || as.Series == nil {
+			return nil, fmt.Errorf("alert %q has no series", name)
+		}
+		for key, e := range as.Series {
+			if e == nil {
+				return nil, fmt.Errorf("alert %q has an empty series %q", name, key)
+			}
+		}
+	}
+	return &cp, nil
+}
+
+// mergeState folds other into rules, keeping the larger value of every
+// series. Counters are cumulative, so the larger value has seen more data.
+// Open panes of windowed rules are merged the same way, unique_by filters
+// are merged into their union.
+func mergeState(rules, other map[string]*ruleState) error {
+	var errs []error
+	for name, otherState := range other {
+		state, ok := rules[name]
+		if !ok {
+			rules[name] = otherState
+			continue
+		}
+		if otherState.MaxEventTime.After(state.MaxEventTime) {
+			state.MaxEventTime = otherState.MaxEventTime
+		}
+		for key, o := range otherState.Series {
+			s, ok := state.Series[key]
+			if !ok {
+				state.Series[key] = o
+				continue
+			}
+			if err := s.aggregate.reconcile(&o.aggregate); err != nil {
+				errs = append(errs, fmt.Errorf("rule %q series %q: %w", name, key, err))
+			}
+			if o.StartTime.Before(s.StartTime) {
+				s.StartTime = o.StartTime
+			}
+			if o.LastSeen.After(s.LastSeen) {
+				s.LastSeen = o.LastSeen
+			}
+			if o.Emitted.After(s.Emitted) {
+				s.Emitted = o.Emitted
+			}
+			switch {
+			case s.Unique == nil:
+				s.Unique = o.Unique
+			case o.Unique != nil:
+				s.Unique.merge(o.Unique)
+			}
+			for start, op := range o.Panes {
+				if s.Panes == nil {
+					s.Panes = make(map[int64]*pane)
+				}
+				sp, ok := s.Panes[start]
+				if !ok {
+					s.Panes[start] = op
+					continue
+				}
+				if err := sp.aggregate.reconcile(&op.aggregate); err != nil {
+					errs = append(errs, fmt.Errorf("rule %q series %q pane %d: %w", name, key, start, err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// add adds the value of dp to a and returns how much Value grew. Integers
+// add up exactly, doubles, e.g. converted units, go through addFraction.
+func (a *aggregate) add(dp pmetric.NumberDataPoint) int64 {
+	if dp.ValueType() == pmetric.NumberDataPointValueTypeInt {
+		a.Value += dp.IntValue()
+		return dp.IntValue()
+	}
+	return a.addFraction(dp.DoubleValue())
+}
+
+// addFraction adds v to a and returns how much Value grew. The integer part
+// of v is added to Value, the fraction is carried in Remainder until it makes
+// a whole count, so Value is the rounded total.
+func (a *aggregate) addFraction(v float64) int64 {
+	whole, fraction := math.Modf(v)
+	a.Remainder += fraction
+	carry := math.Round(a.Remainder)
+	a.Remainder -= carry
+	grown := int64(whole) + int64(carry)
+	a.Value += grown
+	return grown
+}
+
+// reconcile folds another copy of the same aggregate into a, keeping the
+// larger value. Distinct count sketches are merged into their union. Quantile
+// and top_k sketches can't be merged without counting shared observations
+// twice, so the one with the larger value is kept.
+func (a *aggregate) reconcile(o *aggregate) error {
+	if o.Value > a.Value {
+		a.Value, a.Remainder = o.Value, o.Remainder
+		if o.Quantile != nil {
+			a.Quantile = o.Quantile
+		}
+		if o.TopK != nil {
+			a.TopK = o.TopK
+		}
+	}
+	switch {
+	case a.Distinct == nil:
+		a.Distinct = o.Distinct
+	case o.Distinct != nil:
+		return a.Distinct.merge(o.Distinct)
+	}
+	return nil
+}
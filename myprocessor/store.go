@@ -0,0 +1,90 @@
+package simpleprocessor
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+)
+
+// stateStore persists checkpoint documents by key.
+type stateStore interface {
+	// load returns (nil, nil) when nothing has been saved under key yet.
+	load(ctx context.Context, key string) ([]byte, error)
+	save(ctx context.Context, key string, data []byte) error
+	// delete succeeds when nothing is saved under key.
+	delete(ctx context.Context, key string) error
+	close(ctx context.Context) error
+	String() string
+}
+
+// fileStore keeps the checkpoint in a local file. Keys other than the main
+// checkpoint key are written next to it with the key as a suffix.
+type fileStore struct {
+	path string
+}
+
+func (s *fileStore) file(key string) string {
+	if key == checkpointKey {
+		return s.path
+	}
+	return s.path + "." + key
+}
+
+func (s *fileStore) load(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.file(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *fileStore) save(_ context.Context, key string, data []byte) error {
+	// Write to a temporary file first so a crash never leaves a torn checkpoint.
+	tmp := s.file(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file(key))
+}
+
+func (s *fileStore) delete(_ context.Context, key string) error {
+	if err := os.Remove(s.file(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) close(context.Context) error {
+	return nil
+}
+
+func (s *fileStore) String() string {
+	return "file " + s.path
+}
+
+// extensionStore keeps the checkpoint in a storage extension such as Redis.
+type extensionStore struct {
+	client storage.Client
+	name   string
+}
+
+func (s *extensionStore) load(ctx context.Context, key string) ([]byte, error) {
+	return s.client.Get(ctx, key)
+}
+
+func (s *extensionStore) save(ctx context.Context, key string, data []byte) error {
+	return s.client.Set(ctx, key, data)
+}
+
+func (s *extensionStore) delete(ctx context.Context, key string) error {
+	return s.client.Delete(ctx, key)
+}
+
+func (s *extensionStore) close(ctx context.Context) error {
+	return s.client.Close(ctx)
+}
+
+func (s *extensionStore) String() string {
+	return "storage " + s.name
+}
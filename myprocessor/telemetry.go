@@ -0,0 +1,206 @@
+package simpleprocessor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const scopeName = "github.com/myuser/simpleprocessor"
+
+// metricPrefix prefixes the names of the instruments, which are scoped by the
+// meter rather than carrying an exporter specific prefix. Prometheus turns the
+// dots into underscores.
+const metricPrefix = "processor.simple."
+
+// Reasons a data point is not aggregated.
+const (
+	dropNoMatchingRule  = "no_matching_rule"
+	dropMissingGroupKey = "missing_group_key"
+	dropInvalidValue    = "invalid_value"
+	dropLate            = "late"
+	dropDuplicate       = "duplicate"
+	dropNotUnique       = "not_unique"
+	dropMissingTenant   = "missing_tenant"
+	dropTenantLimit     = "tenant_limit"
+	dropSeriesLimit     = "series_limit"
+	dropUnitMismatch    = "unit_mismatch"
+	dropNegativeValue   = "negative_value"
+	dropValueTooLarge   = "value_too_large"
+	dropCorruptState    = "corrupt_state"
+)
+
+// How data points that arrive after their window was flushed are handled.
+const (
+	lateDropped   = "dropped"
+	lateCurrent   = "current"
+	lateCorrected = "corrected"
+)
+
+// countKey counts data points per tenant, rule and reason.
+type countKey struct {
+	tenant, rule, reason string
+}
+
+// Operations that take the processor lock.
+const (
+	opConsume    = "consume"
+	opFlush      = "flush"
+	opCheckpoint = "checkpoint"
+	opAdmin      = "admin"
+)
+
+// telemetry holds the instruments the processor reports about itself.
+type telemetry struct {
+	meter metric.Meter
+
+	activeSeries        metric.Int64ObservableGauge
+	aggregated          metric.Int64Counter
+	dropped             metric.Int64Counter
+	late                metric.Int64Counter
+	flushDuration       metric.Float64Histogram
+	flushFailures       metric.Int64Counter
+	checkpointSize      metric.Int64Histogram
+	checkpointDuration  metric.Float64Histogram
+	checkpointFailures  metric.Int64Counter
+	lockWait            metric.Float64Histogram
+	registeredCallbacks []metric.Registration
+}
+
+func newTelemetry(set component.TelemetrySettings) (*telemetry, error) {
+	t := &telemetry{meter: set.MeterProvider.Meter(scopeName)}
+	var err, errs error
+
+	t.activeSeries, err = t.meter.Int64ObservableGauge(metricPrefix+"active_series",
+		metric.WithDescription("Number of series currently held in memory."),
+		metric.WithUnit("{series}"))
+	errs = errors.Join(errs, err)
+	t.aggregated, err = t.meter.Int64Counter(metricPrefix+"datapoints_aggregated",
+		metric.WithDescription("Number of data points added to the aggregation state."),
+		metric.WithUnit("{datapoints}"))
+	errs = errors.Join(errs, err)
+	t.dropped, err = t.meter.Int64Counter(metricPrefix+"datapoints_dropped",
+		metric.WithDescription("Number of data points not aggregated, by reason."),
+		metric.WithUnit("{datapoints}"))
+	errs = errors.Join(errs, err)
+	t.late, err = t.meter.Int64Counter(metricPrefix+"datapoints_late",
+		metric.WithDescription("Number of data points that arrived after their window was flushed, by how they were handled."),
+		metric.WithUnit("{datapoints}"))
+	errs = errors.Join(errs, err)
+	t.flushDuration, err = t.meter.Float64Histogram(metricPrefix+"flush_duration",
+		metric.WithDescription("Time taken to build and send a flush to the next consumer."),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+	t.flushFailures, err = t.meter.Int64Counter(metricPrefix+"flush_failures",
+		metric.WithDescription("Number of flushes the next consumer rejected."),
+		metric.WithUnit("{flushes}"))
+	errs = errors.Join(errs, err)
+	t.checkpointSize, err = t.meter.Int64Histogram(metricPrefix+"checkpoint_size",
+		metric.WithDescription("Size of the encoded checkpoint."),
+		metric.WithUnit("By"))
+	errs = errors.Join(errs, err)
+	t.checkpointDuration, err = t.meter.Float64Histogram(metricPrefix+"checkpoint_duration",
+		metric.WithDescription("Time taken to write a checkpoint to a store, by outcome."),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+	t.checkpointFailures, err = t.meter.Int64Counter(metricPrefix+"checkpoint_failures",
+		metric.WithDescription("Number of failed checkpoint writes, by store."),
+		metric.WithUnit("{checkpoints}"))
+	errs = errors.Join(errs, err)
+	t.lockWait, err = t.meter.Float64Histogram(metricPrefix+"lock_wait_duration",
+		metric.WithDescription("Time spent waiting for the aggregation state lock, by operation."),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+
+	return t, errs
+}
+
+// observeActiveSeries registers fn to report the number of series per tenant
+// and rule.
+func (t *telemetry) observeActiveSeries(fn func() map[countKey]int) error {
+	reg, err := t.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for k, n := range fn() {
+			o.ObserveInt64(t.activeSeries, int64(n), metric.WithAttributes(tenantAttributes(k.tenant, attribute.String("rule", k.rule))...))
+		}
+		return nil
+	}, t.activeSeries)
+	if err != nil {
+		return err
+	}
+	t.registeredCallbacks = append(t.registeredCallbacks, reg)
+	return nil
+}
+
+func (t *telemetry) recordAggregated(ctx context.Context, tenant, rule string, n int64) {
+	if n == 0 {
+		return
+	}
+	t.aggregated.Add(ctx, n, metric.WithAttributes(tenantAttributes(tenant, attribute.String("rule", rule))...))
+}
+
+// recordDropped counts data points that were not aggregated. rule is empty
+// when they were dropped before reaching any rule, tenant when they were
+// dropped before their tenant was known.
+func (t *telemetry) recordDropped(ctx context.Context, tenant, rule, reason string, n int64) {
+	if n == 0 {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("reason", reason)}
+	if rule != "" {
+		attrs = append(attrs, attribute.String("rule", rule))
+	}
+	t.dropped.Add(ctx, n, metric.WithAttributes(tenantAttributes(tenant, attrs...)...))
+}
+
+// recordLate counts data points that arrived after their window was flushed.
+func (t *telemetry) recordLate(ctx context.Context, tenant, rule, handling string, n int64) {
+	if n == 0 {
+		return
+	}
+	t.late.Add(ctx, n, metric.WithAttributes(tenantAttributes(tenant,
+		attribute.String("rule", rule), attribute.String("handling", handling))...))
+}
+
+// tenantAttributes adds the tenant to attrs, unless there is none.
+func tenantAttributes(tenant string, attrs ...attribute.KeyValue) []attribute.KeyValue {
+	if tenant == "" {
+		return attrs
+	}
+	return append(attrs, attribute.String("tenant", tenant))
+}
+
+func (t *telemetry) recordFlush(ctx context.Context, d time.Duration, err error) {
+	t.flushDuration.Record(ctx, d.Seconds())
+	if err != nil {
+		t.flushFailures.Add(ctx, 1)
+	}
+}
+
+func (t *telemetry) recordCheckpoint(ctx context.Context, store stateStore, size int, d time.Duration, err error) {
+	storeAttr := attribute.String("store", store.String())
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		t.checkpointFailures.Add(ctx, 1, metric.WithAttributes(storeAttr))
+	} else {
+		t.checkpointSize.Record(ctx, int64(size), metric.WithAttributes(storeAttr))
+	}
+	t.checkpointDuration.Record(ctx, d.Seconds(), metric.WithAttributes(storeAttr, attribute.String("outcome", outcome)))
+}
+
+func (t *telemetry) recordLockWait(ctx context.Context, op string, d time.Duration) {
+	t.lockWait.Record(ctx, d.Seconds(), metric.WithAttributes(attribute.String("operation", op)))
+}
+
+func (t *telemetry) shutdown() error {
+	var errs error
+	for _, reg := range t.registeredCallbacks {
+		errs = errors.Join(errs, reg.Unregister())
+	}
+	t.registeredCallbacks = nil
+	return errs
+}
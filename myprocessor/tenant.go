@@ -0,0 +1,222 @@
+package simpleprocessor
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// Sources the tenant of a data point can be taken from.
+const (
+	// TenantSourceResourceAttribute takes the tenant from a resource attribute.
+	TenantSourceResourceAttribute = "resource_attribute"
+	// TenantSourceAttribute takes the tenant from a data point attribute.
+	TenantSourceAttribute = "attribute"
+	// TenantSourceMetadata takes the tenant from the client metadata of the
+	// request, e.g. an OTLP header. The receiver must set include_metadata.
+	TenantSourceMetadata = "metadata"
+)
+
+const defaultTenantOutputAttribute = "tenant"
+
+// TenancyConfig configures aggregating every tenant on its own. Tenants keep
+// separate series, deduplication state and checkpoints.
+type TenancyConfig struct {
+	// Source is resource_attribute, attribute or metadata.
+	Source string `mapstructure:"source"`
+
+	// Key is the attribute or metadata key holding the tenant, e.g.
+	// X-Scope-OrgID.
+	Key string `mapstructure:"key"`
+
+	// Default is the tenant of data points that don't name one. Without it
+	// they are dropped.
+	Default string `mapstructure:"default"`
+
+	// OutputAttribute is the resource attribute flushed metrics carry the
+	// tenant in. Defaults to tenant.
+	OutputAttribute string `mapstructure:"output_attribute"`
+
+	// MaxSeries bounds the number of series a tenant holds over all rules.
+	// Data points that would start a new series beyond it are dropped. Zero
+	// means no limit.
+	MaxSeries int `mapstructure:"max_series"`
+
+	// MaxTenants bounds the number of tenants held. Data points of a new
+	// tenant beyond it are aggregated as OverflowTenant, or dropped without
+	// one. Zero means no limit.
+	MaxTenants int `mapstructure:"max_tenants"`
+
+	// OverflowTenant takes the data points of tenants beyond MaxTenants.
+	OverflowTenant string `mapstructure:"overflow_tenant"`
+
+	// IdleTimeout evicts tenants that received no data point for this long,
+	// with their series and checkpoint. Zero keeps them.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+}
+
+// Validate checks the configuration.
+func (c *TenancyConfig) Validate() error {
+	switch c.Source {
+	case TenantSourceResourceAttribute, TenantSourceAttribute, TenantSourceMetadata:
+	default:
+		return errors.New("source must be resource_attribute, attribute or metadata")
+	}
+	if c.Key == "" {
+		return errors.New("key must be set")
+	}
+	if c.MaxSeries < 0 {
+		return errors.New("max_series must not be negative")
+	}
+	if c.MaxTenants < 0 {
+		return errors.New("max_tenants must not be negative")
+	}
+	if c.OverflowTenant != "" && c.MaxTenants == 0 {
+		return errors.New("overflow_tenant needs max_tenants")
+	}
+	if c.IdleTimeout < 0 {
+		return errors.New("idle_timeout must not be negative")
+	}
+	return nil
+}
+
+func (c *TenancyConfig) outputAttribute() string {
+	if c.OutputAttribute == "" {
+		return defaultTenantOutputAttribute
+	}
+	return c.OutputAttribute
+}
+
+// tenant returns the tenant of a data point, or false if it has none and
+// there is no default.
+func (c *TenancyConfig) tenant(info client.Info, resource pcommon.Resource, dp pmetric.NumberDataPoint) (string, bool) {
+	var tenant string
+	switch c.Source {
+	case TenantSourceMetadata:
+		if values := info.Metadata.Get(c.Key); len(values) > 0 {
+			tenant = values[0]
+		}
+	case TenantSourceResourceAttribute:
+		if v, ok := resource.Attributes().Get(c.Key); ok {
+			tenant = v.AsString()
+		}
+	default:
+		if v, ok := dp.Attributes().Get(c.Key); ok {
+			tenant = v.AsString()
+		}
+	}
+	if tenant == "" {
+		tenant = c.Default
+	}
+	return tenant, tenant != ""
+}
+
+// tenantState is the state of one tenant. Without tenancy all state belongs
+// to the tenant "".
+type tenantState struct {
+	rules   map[string]*ruleState  // Series by rule
+	dedup   *dedupSet              // nil unless deduplication is on
+	alerts  map[string]*alertState // Alert state by alert, created on first evaluation
+	samples *sampleStore           // Flushed samples for recording rules, in memory only
+
+	// rates are the values rate() of derived metrics saw at the previous
+	// flush, by rate and group key.
+	rates map[string]map[string]alertSample
+
+	// lastSeen is when the tenant last received a data point, or when it
+	// was loaded. It drives idle eviction and isn't checkpointed.
+	lastSeen time.Time
+}
+
+// seriesCount returns the number of series of the tenant over all rules.
+func (t *tenantState) seriesCount() int {
+	n := 0
+	for _, state := range t.rules {
+		n += len(state.Series)
+	}
+	return n
+}
+
+// tenantKey is the key the checkpoint of a tenant is stored under. The state
+// of the tenant "" is the main checkpoint, which lists the other tenants.
+func tenantKey(tenant string) string {
+	if tenant == "" {
+		return checkpointKey
+	}
+	return checkpointKey + "." + url.PathEscape(tenant)
+}
+
+// admitTenantLocked returns the tenant a data point is aggregated as, which is
+// the overflow tenant once max_tenants is reached, or false when it is
+// dropped.
+func (p *simpleProcessor) admitTenantLocked(tenant string) (string, bool) {
+	c := p.cfg.Tenancy
+	if _, ok := p.tenants[tenant]; ok || c.MaxTenants == 0 || tenant == c.OverflowTenant {
+		return tenant, true
+	}
+	n := len(p.tenants)
+	// Neither the state from before tenancy nor the overflow tenant count.
+	for _, name := range []string{"", c.OverflowTenant} {
+		if _, ok := p.tenants[name]; ok {
+			n--
+		}
+	}
+	if n < c.MaxTenants {
+		return tenant, true
+	}
+	if c.OverflowTenant != "" {
+		return c.OverflowTenant, true
+	}
+	return "", false
+}
+
+// evictIdleTenantsLocked drops the tenants that received no data point for
+// idle_timeout. Their checkpoints are deleted once a checkpoint no longer
+// lists them.
+func (p *simpleProcessor) evictIdleTenantsLocked(now time.Time) {
+	if p.cfg.Tenancy == nil || p.cfg.Tenancy.IdleTimeout == 0 {
+		return
+	}
+	cutoff := now.Add(-p.cfg.Tenancy.IdleTimeout)
+	for name, t := range p.tenants {
+		if name == "" || !t.lastSeen.Before(cutoff) {
+			continue
+		}
+		delete(p.tenants, name)
+		if p.primary != nil {
+			p.evicted = append(p.evicted, name)
+		}
+		p.logger.Info("Evicted idle tenant", zap.String("tenant", name), zap.Time("last_seen", t.lastSeen))
+	}
+}
+
+// deleteEvictedLocked deletes the checkpoints of evicted tenants from the
+// stores that were just written.
+func (p *simpleProcessor) deleteEvictedLocked(ctx context.Context) {
+	stores := []stateStore{p.secondary}
+	if !p.degraded {
+		stores = append(stores, p.primary)
+	}
+	for _, tenant := range p.evicted {
+		if _, ok := p.tenants[tenant]; ok {
+			// It came back before its checkpoint was deleted.
+			continue
+		}
+		for _, store := range stores {
+			if store == nil {
+				continue
+			}
+			if err := store.delete(ctx, tenantKey(tenant)); err != nil {
+				p.logger.Warn("Failed to delete checkpoint of evicted tenant", zap.String("tenant", tenant),
+					zap.Stringer("store", store), zap.Error(err))
+			}
+		}
+	}
+	p.evicted = nil
+}
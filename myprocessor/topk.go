@@ -0,0 +1,176 @@
+package simpleprocessor
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+const (
+	defaultTopKOtherValue     = "other"
+	defaultTopKCapacityFactor = 10
+)
+
+// TopKConfig configures the top_k aggregation.
+type TopKConfig struct {
+	// Attribute whose heaviest values are kept per group, e.g. customer.id.
+	Attribute string `mapstructure:"attribute"`
+
+	// K is the number of values flushed per group.
+	K int `mapstructure:"k"`
+
+	// Capacity is the number of values tracked per group, at least K. More
+	// counters make the top K more accurate. Defaults to 10 times K.
+	Capacity int `mapstructure:"capacity"`
+
+	// OtherValue is the attribute value everything outside the top K is
+	// folded into. Defaults to "other".
+	OtherValue string `mapstructure:"other_value"`
+}
+
+// Validate checks the configuration.
+func (c *TopKConfig) Validate() error {
+	if c.Attribute == "" {
+		return errors.New("attribute must be set")
+	}
+	if c.K <= 0 {
+		return errors.New("k must be positive")
+	}
+	if c.Capacity != 0 && c.Capacity < c.K {
+		return errors.New("capacity must be at least k")
+	}
+	return nil
+}
+
+func (c *TopKConfig) capacity() int {
+	if c.Capacity == 0 {
+		return c.K * defaultTopKCapacityFactor
+	}
+	return c.Capacity
+}
+
+func (c *TopKConfig) otherValue() string {
+	if c.OtherValue == "" {
+		return defaultTopKOtherValue
+	}
+	return c.OtherValue
+}
+
+// topKCounter is the weight counted for one value. Count overestimates the
+// true weight by at most Error.
+type topKCounter struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+	Error int64  `json:"error"`
+
+	pos int
+}
+
+// topKSketch finds heavy hitters with the space-saving algorithm: it keeps a
+// fixed number of counters, and a value that isn't tracked takes over the
+// smallest counter, inheriting its count as error.
+type topKSketch struct {
+	capacity int
+	counters topKHeap
+	index    map[string]*topKCounter
+}
+
+func newTopKSketch(capacity int) *topKSketch {
+	return &topKSketch{capacity: capacity, index: make(map[string]*topKCounter)}
+}
+
+func (t *topKSketch) add(value string, weight int64) {
+	if c, ok := t.index[value]; ok {
+		c.Count += weight
+		heap.Fix(&t.counters, c.pos)
+		return
+	}
+	if len(t.counters) < t.capacity {
+		c := &topKCounter{Value: value, Count: weight}
+		heap.Push(&t.counters, c)
+		t.index[value] = c
+		return
+	}
+	c := t.counters[0]
+	delete(t.index, c.Value)
+	c.Value, c.Error, c.Count = value, c.Count, c.Count+weight
+	t.index[value] = c
+	heap.Fix(&t.counters, 0)
+}
+
+// top returns the k heaviest counters, heaviest first.
+func (t *topKSketch) top(k int) []topKCounter {
+	top := make([]topKCounter, 0, len(t.counters))
+	for _, c := range t.counters {
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
+
+type topKSketchJSON struct {
+	Capacity int            `json:"capacity"`
+	Counters []*topKCounter `json:"counters"`
+}
+
+func (t *topKSketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(topKSketchJSON{Capacity: t.capacity, Counters: t.counters})
+}
+
+func (t *topKSketch) UnmarshalJSON(b []byte) error {
+	var v topKSketchJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Capacity <= 0 || len(v.Counters) > v.Capacity {
+		return errors.New("top_k sketch has more counters than its capacity")
+	}
+	*t = *newTopKSketch(v.Capacity)
+	for _, c := range v.Counters {
+		if c == nil {
+			return errors.New("top_k sketch has an empty counter")
+		}
+		if _, ok := t.index[c.Value]; ok {
+			return errors.New("top_k sketch counts a value twice")
+		}
+		t.index[c.Value] = c
+		c.pos = len(t.counters)
+		t.counters = append(t.counters, c)
+	}
+	heap.Init(&t.counters)
+	return nil
+}
+
+// topKHeap orders counters by count, smallest first.
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *topKHeap) Push(x any) {
+	c := x.(*topKCounter)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *topKHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,223 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	defaultUniqueFalsePositiveRate = 0.001
+	defaultUniqueRetention         = 24 * time.Hour
+
+	// uniqueGenerations is the number of generations retention is split
+	// into. A whole generation is forgotten once it is past retention.
+	uniqueGenerations = 4
+	// Every bloom filter added to a generation holds uniqueGrowth times more
+	// values than the previous one, at uniqueTightening times the error rate.
+	// The first one is small since it is in every checkpoint, even for series
+	// that see few values.
+	uniqueInitialCapacity = 128
+	uniqueGrowth          = 2
+	uniqueTightening      = 0.5
+)
+
+// UniqueByConfig configures counting every value of an attribute at most once
+// per group, e.g. every work.id once per work.type.
+type UniqueByConfig struct {
+	// Attribute that identifies a unit of work. Data points repeating a value
+	// already seen by the group are dropped.
+	Attribute string `mapstructure:"attribute"`
+
+	// FalsePositiveRate is the chance that a new value is taken for one
+	// already seen and dropped. Defaults to 0.001.
+	FalsePositiveRate float64 `mapstructure:"false_positive_rate"`
+
+	// Retention is how long a value is remembered. Defaults to 24h.
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// Validate checks the configuration.
+func (c *UniqueByConfig) Validate() error {
+	if c.FalsePositiveRate < 0 || c.FalsePositiveRate >= 1 {
+		return errors.New("false_positive_rate must be between 0 and 1")
+	}
+	if c.Retention < 0 {
+		return errors.New("retention must not be negative")
+	}
+	return nil
+}
+
+func (c *UniqueByConfig) enabled() bool {
+	return c.Attribute != ""
+}
+
+func (c *UniqueByConfig) falsePositiveRate() float64 {
+	if c.FalsePositiveRate == 0 {
+		return defaultUniqueFalsePositiveRate
+	}
+	return c.FalsePositiveRate
+}
+
+func (c *UniqueByConfig) retention() time.Duration {
+	if c.Retention == 0 {
+		return defaultUniqueRetention
+	}
+	return c.Retention
+}
+
+// uniqueFilter remembers the values a series has seen for a limited time. It
+// is a list of generations, each a scalable bloom filter that grows as values
+// are added. Values are added to the newest generation and looked up in all.
+type uniqueFilter struct {
+	Generations []*filterGeneration `json:"generations"`
+}
+
+type filterGeneration struct {
+	Start   time.Time      `json:"start"`
+	Filters []*bloomFilter `json:"filters"`
+}
+
+// contains reports whether value was seen within the retention, forgetting
+// the generations past it.
+func (u *uniqueFilter) contains(cfg *UniqueByConfig, value string, now time.Time) bool {
+	retention := cfg.retention()
+	length := retention / uniqueGenerations
+
+	live := u.Generations[:0]
+	for _, g := range u.Generations {
+		if g.Start.Add(length).After(now.Add(-retention)) {
+			live = append(live, g)
+		}
+	}
+	u.Generations = live
+
+	h1, h2 := bloomHashes(value)
+	for _, g := range u.Generations {
+		for _, f := range g.Filters {
+			if f.contains(h1, h2) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// add remembers value in the newest generation.
+func (u *uniqueFilter) add(cfg *UniqueByConfig, value string, now time.Time) {
+	length := cfg.retention() / uniqueGenerations
+	if len(u.Generations) == 0 || !now.Before(u.Generations[len(u.Generations)-1].Start.Add(length)) {
+		u.Generations = append(u.Generations, &filterGeneration{Start: now})
+	}
+	g := u.Generations[len(u.Generations)-1]
+	if len(g.Filters) == 0 || g.Filters[len(g.Filters)-1].full() {
+		// Each generation gets an equal share of the error rate, split over
+		// its filters so that the rates add up to at most that share.
+		rate := cfg.falsePositiveRate() / (uniqueGenerations + 1) * (1 - uniqueTightening)
+		n := len(g.Filters)
+		g.Filters = append(g.Filters, newBloomFilter(
+			uniqueInitialCapacity*int(math.Pow(uniqueGrowth, float64(n))),
+			rate*math.Pow(uniqueTightening, float64(n))))
+	}
+	g.Filters[len(g.Filters)-1].add(bloomHashes(value))
+}
+
+// merge adds the values remembered by other to u.
+func (u *uniqueFilter) merge(other *uniqueFilter) {
+	for _, og := range other.Generations {
+		var g *filterGeneration
+		for _, candidate := range u.Generations {
+			if candidate.Start.Equal(og.Start) {
+				g = candidate
+				break
+			}
+		}
+		if g == nil {
+			u.Generations = append(u.Generations, og)
+			continue
+		}
+		for i, of := range og.Filters {
+			if i < len(g.Filters) && g.Filters[i].union(of) {
+				continue
+			}
+			g.Filters = append(g.Filters, of)
+		}
+	}
+	sort.Slice(u.Generations, func(i, j int) bool {
+		return u.Generations[i].Start.Before(u.Generations[j].Start)
+	})
+}
+
+// bloomFilter is a fixed size bloom filter sized for Capacity values.
+type bloomFilter struct {
+	Bits     []byte `json:"bits"`
+	Hashes   int    `json:"hashes"`
+	Capacity int    `json:"capacity"`
+	Count    int    `json:"count"`
+}
+
+func newBloomFilter(capacity int, rate float64) *bloomFilter {
+	m := math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := max(1, int(math.Round(m/float64(capacity)*math.Ln2)))
+	return &bloomFilter{Bits: make([]byte, (int(m)+7)/8), Hashes: k, Capacity: capacity}
+}
+
+// full reports whether the filter holds as many values as it was sized for.
+// A filter without bits, e.g. from a damaged checkpoint, is always full.
+func (f *bloomFilter) full() bool {
+	return f.Count >= f.Capacity || len(f.Bits) == 0
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	m := uint64(len(f.Bits)) * 8
+	for i := 0; i < f.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		f.Bits[bit/8] |= 1 << (bit % 8)
+	}
+	f.Count++
+}
+
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	m := uint64(len(f.Bits)) * 8
+	if m == 0 {
+		return false
+	}
+	for i := 0; i < f.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if f.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// union ORs other into f. It returns false when the filters differ in shape.
+func (f *bloomFilter) union(other *bloomFilter) bool {
+	if len(f.Bits) != len(other.Bits) || f.Hashes != other.Hashes {
+		return false
+	}
+	for i := range f.Bits {
+		f.Bits[i] |= other.Bits[i]
+	}
+	f.Count = max(f.Count, other.Count)
+	return true
+}
+
+// bloomHashes derives the two hashes bloom filter positions are built from.
+// FNV alone hardly changes for similar values such as sequential IDs, so
+// its result is run through the splitmix64 finalizer.
+func bloomHashes(value string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	// A zero second hash would put every probe at the same position.
+	return mix64(x), mix64(x+0x9e3779b97f4a7c15) | 1
+}
+
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,397 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// What happens to data points whose unit can't be converted.
+const (
+	// UnitMismatchDrop drops them.
+	UnitMismatchDrop = "drop"
+	// UnitMismatchSeparate aggregates them unconverted, in series of their
+	// own with the unit attribute.
+	UnitMismatchSeparate = "separate"
+)
+
+// unitAttribute keeps the series of data points that couldn't be converted
+// apart, by their unit.
+const unitAttribute = "unit"
+
+// UnitConfig converts the data points of a metrics rule to one unit.
+type UnitConfig struct {
+	// Target is the UCUM unit data points are converted to and the rule is
+	// flushed with, e.g. ms or By. Empty leaves values as they are.
+	Target string `mapstructure:"target"`
+
+	// Mismatch is what happens to data points whose unit can't be converted
+	// to the target: drop (default) or separate.
+	Mismatch string `mapstructure:"mismatch"`
+}
+
+func (c *UnitConfig) enabled() bool {
+	return c.Target != ""
+}
+
+func (c *UnitConfig) mismatch() string {
+	if c.Mismatch == "" {
+		return UnitMismatchDrop
+	}
+	return c.Mismatch
+}
+
+// Validate checks the configuration.
+func (c *UnitConfig) Validate() error {
+	if !c.enabled() {
+		if c.Mismatch != "" {
+			return errors.New("mismatch needs a target")
+		}
+		return nil
+	}
+	if _, err := parseUnit(c.Target); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	switch c.mismatch() {
+	case UnitMismatchDrop, UnitMismatchSeparate:
+	default:
+		return fmt.Errorf("unknown mismatch %q", c.Mismatch)
+	}
+	return nil
+}
+
+// unitConversion converts the data points of one input metric for the
+// matched rules that have a target unit.
+type unitConversion struct {
+	matched []*rule
+	unit    string    // of the input metric
+	factors []float64 // by matched rule, 0 when the unit doesn't convert
+}
+
+// newUnitConversion returns the conversion of a metric with unit for the
+// matched rules, or nil when none has a target. A metric without a unit is
+// taken to be in the target unit.
+func newUnitConversion(matched []*rule, unit string) *unitConversion {
+	var c *unitConversion
+	for i, r := range matched {
+		if !r.Unit.enabled() {
+			continue
+		}
+		if c == nil {
+			c = &unitConversion{matched: matched, unit: unit, factors: make([]float64, len(matched))}
+			for j := range c.factors {
+				c.factors[j] = 1
+			}
+		}
+		if unit == "" {
+			continue
+		}
+		c.factors[i] = 0
+		if from, err := parseUnit(unit); err == nil {
+			c.factors[i], _ = from.factorTo(r.target)
+		}
+	}
+	return c
+}
+
+// prepare returns the prepare function of dp for aggregateLocked. Every rule
+// starts from the value and attributes dp arrived with.
+func (c *unitConversion) prepare(dp pmetric.NumberDataPoint) func(i int) string {
+	isInt := dp.ValueType() == pmetric.NumberDataPointValueTypeInt
+	intValue, value := dp.IntValue(), numberValue(dp)
+	tagged := false
+	return func(i int) string {
+		if isInt {
+			dp.SetIntValue(intValue)
+		} else {
+			dp.SetDoubleValue(value)
+		}
+		if tagged {
+			dp.Attributes().Remove(unitAttribute)
+			tagged = false
+		}
+
+		r := c.matched[i]
+		factor := c.factors[i]
+		switch {
+		case factor == 1:
+		case factor == 0:
+			if r.Unit.mismatch() == UnitMismatchDrop {
+				return dropUnitMismatch
+			}
+			if _, ok := dp.Attributes().Get(unitAttribute); !ok {
+				dp.Attributes().PutStr(unitAttribute, c.unit)
+				tagged = true
+			}
+		default:
+			// Sums carry the fraction over to the next value, see
+			// aggregate.addFraction.
+			dp.SetDoubleValue(value * factor)
+		}
+		return ""
+	}
+}
+
+// ucumUnit is a parsed UCUM unit: a factor to the base units and the
+// exponent of each base unit.
+type ucumUnit struct {
+	factor float64
+	dims   [ucumDims]int
+}
+
+// Base units, the dimensions of ucumUnit.
+const (
+	dimLength      = iota // m
+	dimTime               // s
+	dimMass               // g
+	dimInformation        // By
+	dimTemperature        // K
+	dimCurrent            // A
+	dimAmount             // mol
+	dimLuminosity         // cd
+	ucumDims
+)
+
+// factorTo returns the factor converting values in u to to, and false when
+// they measure different things.
+func (u ucumUnit) factorTo(to *ucumUnit) (float64, bool) {
+	if u.dims != to.dims {
+		return 0, false
+	}
+	return u.factor / to.factor, true
+}
+
+func (u ucumUnit) mul(v ucumUnit, exp int) ucumUnit {
+	u.factor *= math.Pow(v.factor, float64(exp))
+	for i := range u.dims {
+		u.dims[i] += v.dims[i] * exp
+	}
+	return u
+}
+
+// ucumAtom is a unit symbol. Metric ones take prefixes.
+type ucumAtom struct {
+	unit   ucumUnit
+	metric bool
+}
+
+func ucumBase(dim int) ucumUnit {
+	u := ucumUnit{factor: 1}
+	u.dims[dim] = 1
+	return u
+}
+
+func ucumScaled(factor float64, u ucumUnit) ucumUnit {
+	u.factor *= factor
+	return u
+}
+
+var (
+	ucumOne    = ucumUnit{factor: 1}
+	ucumSecond = ucumBase(dimTime)
+	ucumMeter  = ucumBase(dimLength)
+	ucumGram   = ucumBase(dimMass)
+	ucumJoule  = ucumScaled(1000, ucumGram.mul(ucumMeter, 2).mul(ucumSecond, -2))
+
+	ucumAtoms = map[string]ucumAtom{
+		"%":   {ucumScaled(0.01, ucumOne), false},
+		"m":   {ucumMeter, true},
+		"s":   {ucumSecond, true},
+		"min": {ucumScaled(60, ucumSecond), false},
+		"h":   {ucumScaled(3600, ucumSecond), false},
+		"d":   {ucumScaled(86400, ucumSecond), false},
+		"wk":  {ucumScaled(604800, ucumSecond), false},
+		"g":   {ucumGram, true},
+		"t":   {ucumScaled(1e6, ucumGram), true},
+		"By":  {ucumBase(dimInformation), true},
+		"bit": {ucumScaled(0.125, ucumBase(dimInformation)), true},
+		"K":   {ucumBase(dimTemperature), true},
+		"A":   {ucumBase(dimCurrent), true},
+		"mol": {ucumBase(dimAmount), true},
+		"cd":  {ucumBase(dimLuminosity), true},
+		"Hz":  {ucumOne.mul(ucumSecond, -1), true},
+		"l":   {ucumScaled(1e-3, ucumOne.mul(ucumMeter, 3)), true},
+		"L":   {ucumScaled(1e-3, ucumOne.mul(ucumMeter, 3)), true},
+		"N":   {ucumScaled(1000, ucumGram.mul(ucumMeter, 1).mul(ucumSecond, -2)), true},
+		"Pa":  {ucumScaled(1000, ucumGram.mul(ucumMeter, -1).mul(ucumSecond, -2)), true},
+		"J":   {ucumJoule, true},
+		"W":   {ucumJoule.mul(ucumSecond, -1), true},
+	}
+
+	// ucumPrefixes of two letters come first, so da is not read as d.
+	ucumPrefixes = []ucumPrefix{
+		{"da", 1e1},
+		{"Y", 1e24}, {"Z", 1e21}, {"E", 1e18}, {"P", 1e15}, {"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"k", 1e3},
+		{"h", 1e2}, {"d", 1e-1}, {"c", 1e-2}, {"m", 1e-3}, {"u", 1e-6}, {"n", 1e-9}, {"p", 1e-12}, {"f", 1e-15},
+		{"a", 1e-18}, {"z", 1e-21}, {"y", 1e-24},
+	}
+
+	// ucumBinaryPrefixes only apply to information units, By and bit.
+	ucumBinaryPrefixes = []ucumPrefix{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	}
+)
+
+type ucumPrefix struct {
+	symbol string
+	factor float64
+}
+
+// parseUnit parses the common subset of UCUM: atoms with prefixes and
+// exponents, combined with . and /, parentheses and {annotations}, e.g.
+// ms, KiBy, By/s, m.s-2 or {requests}/min.
+func parseUnit(s string) (*ucumUnit, error) {
+	p := &unitParser{s: s}
+	u, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q in unit %q", p.s[p.pos:], s)
+	}
+	return &u, nil
+}
+
+type unitParser struct {
+	s   string
+	pos int
+}
+
+func (p *unitParser) term() (ucumUnit, error) {
+	u := ucumOne
+	exp := 1
+	if p.pos < len(p.s) && p.s[p.pos] == '/' {
+		p.pos++
+		exp = -1
+	}
+	for {
+		c, err := p.component()
+		if err != nil {
+			return ucumUnit{}, err
+		}
+		u = u.mul(c, exp)
+		if p.pos == len(p.s) || p.s[p.pos] != '.' && p.s[p.pos] != '/' {
+			return u, nil
+		}
+		exp = 1
+		if p.s[p.pos] == '/' {
+			exp = -1
+		}
+		p.pos++
+	}
+}
+
+func (p *unitParser) component() (ucumUnit, error) {
+	if p.pos == len(p.s) {
+		return ucumUnit{}, fmt.Errorf("unit %q ends unexpectedly", p.s)
+	}
+	if p.s[p.pos] == '{' {
+		// An annotation alone is a dimensionless count, e.g. {requests}.
+		return ucumOne, p.annotation()
+	}
+	var u ucumUnit
+	if p.s[p.pos] == '(' {
+		p.pos++
+		var err error
+		if u, err = p.term(); err != nil {
+			return ucumUnit{}, err
+		}
+		if p.pos == len(p.s) || p.s[p.pos] != ')' {
+			return ucumUnit{}, fmt.Errorf("missing ) in unit %q", p.s)
+		}
+		p.pos++
+	} else {
+		start := p.pos
+		for p.pos < len(p.s) && !strings.ContainsRune("./(){}+-0123456789", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		symbol := p.s[start:p.pos]
+		if symbol == "" {
+			// A number, e.g. 1 in 1/s.
+			for p.pos < len(p.s) && isDigit(p.s[p.pos]) {
+				p.pos++
+			}
+			symbol = p.s[start:p.pos]
+			if symbol == "" {
+				return ucumUnit{}, fmt.Errorf("unexpected %q in unit %q", p.s[p.pos:], p.s)
+			}
+			n, err := strconv.ParseFloat(symbol, 64)
+			if err != nil {
+				return ucumUnit{}, fmt.Errorf("invalid number %q in unit %q", symbol, p.s)
+			}
+			return ucumScaled(n, ucumOne), nil
+		}
+		var ok bool
+		if u, ok = lookupAtom(symbol); !ok {
+			return ucumUnit{}, fmt.Errorf("unknown unit %q", symbol)
+		}
+	}
+
+	exp, err := p.exponent()
+	if err != nil {
+		return ucumUnit{}, err
+	}
+	u = ucumOne.mul(u, exp)
+	if p.pos < len(p.s) && p.s[p.pos] == '{' {
+		return u, p.annotation()
+	}
+	return u, nil
+}
+
+// exponent reads the exponent after a unit, 1 when there is none.
+func (p *unitParser) exponent() (int, error) {
+	start := p.pos
+	if p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-') {
+		p.pos++
+	}
+	for p.pos < len(p.s) && isDigit(p.s[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return 1, nil
+	}
+	exp, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		return 0, fmt.Errorf("invalid exponent %q in unit %q", p.s[start:p.pos], p.s)
+	}
+	return exp, nil
+}
+
+func (p *unitParser) annotation() error {
+	end := strings.IndexByte(p.s[p.pos:], '}')
+	if end < 0 {
+		return fmt.Errorf("missing } in unit %q", p.s)
+	}
+	p.pos += end + 1
+	return nil
+}
+
+// lookupAtom finds a unit symbol, with or without a prefix.
+func lookupAtom(symbol string) (ucumUnit, bool) {
+	if a, ok := ucumAtoms[symbol]; ok {
+		return a.unit, true
+	}
+	for _, prefix := range ucumBinaryPrefixes {
+		rest, ok := strings.CutPrefix(symbol, prefix.symbol)
+		if !ok {
+			continue
+		}
+		if a, ok := ucumAtoms[rest]; ok && a.unit.dims == ucumBase(dimInformation).dims {
+			return ucumScaled(prefix.factor, a.unit), true
+		}
+	}
+	for _, prefix := range ucumPrefixes {
+		rest, ok := strings.CutPrefix(symbol, prefix.symbol)
+		if !ok {
+			continue
+		}
+		if a, ok := ucumAtoms[rest]; ok && a.metric {
+			return ucumScaled(prefix.factor, a.unit), true
+		}
+	}
+	return ucumUnit{}, false
+}
@@ -0,0 +1,140 @@
+package simpleprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// Outputs of quarantined data points.
+const (
+	// QuarantineOutputLogs sends them as log records through the logs
+	// output of the connector.
+	QuarantineOutputLogs = "logs"
+	// QuarantineOutputDebug writes them to the collector's debug log.
+	QuarantineOutputDebug = "debug"
+)
+
+// ValidationConfig rejects data points a rule would otherwise aggregate.
+// NaN and infinite values are always rejected, as are negative values of
+// rules flushed as monotonic sums.
+type ValidationConfig struct {
+	// MaxValue rejects data points with a larger value, after unit
+	// conversion. 0 doesn't cap values.
+	MaxValue float64 `mapstructure:"max_value"`
+}
+
+// Validate checks the configuration.
+func (c *ValidationConfig) Validate() error {
+	if c.MaxValue < 0 || math.IsNaN(c.MaxValue) {
+		return errors.New("max_value must not be negative")
+	}
+	return nil
+}
+
+// QuarantineConfig routes the data points rules reject as invalid somewhere
+// they can be inspected, with the reason they were rejected.
+type QuarantineConfig struct {
+	// Output is logs (default) or debug. Without a logs output, as in the
+	// processor, logs falls back to debug.
+	Output string `mapstructure:"output"`
+}
+
+func (c *QuarantineConfig) output() string {
+	if c.Output == "" {
+		return QuarantineOutputLogs
+	}
+	return c.Output
+}
+
+// Validate checks the configuration.
+func (c *QuarantineConfig) Validate() error {
+	switch c.output() {
+	case QuarantineOutputLogs, QuarantineOutputDebug:
+		return nil
+	default:
+		return fmt.Errorf("unknown output %q", c.Output)
+	}
+}
+
+// validate returns the reason a data point is rejected by the rule, or an
+// empty string when it is valid. Rules that don't aggregate values accept
+// any.
+func (r *rule) validate(dp pmetric.NumberDataPoint) string {
+	if r.aggregation() == AggregationDistinctCount {
+		return ""
+	}
+	v := numberValue(dp)
+	switch {
+	case math.IsNaN(v) || math.IsInf(v, 0):
+		return dropInvalidValue
+	case v < 0 && r.monotonic():
+		return dropNegativeValue
+	case r.Validation.MaxValue > 0 && v > r.Validation.MaxValue:
+		return dropValueTooLarge
+	}
+	return ""
+}
+
+// monotonic reports whether the rule is flushed as a monotonic sum.
+func (r *rule) monotonic() bool {
+	return r.aggregation() == AggregationSum && r.Window.Type != WindowSliding
+}
+
+// quarantineLocked routes a data point rejected by a rule to the quarantine,
+// if there is one.
+func (p *simpleProcessor) quarantineLocked(
+	counts *consumeCounts,
+	tenant string,
+	resource pcommon.Resource,
+	r *rule,
+	dp pmetric.NumberDataPoint,
+	reason string,
+	now time.Time,
+) {
+	q := p.cfg.Quarantine
+	if q == nil {
+		return
+	}
+	if q.output() == QuarantineOutputDebug || p.logsOut == nil {
+		p.logger.Debug("Quarantined data point", zap.String("rule", r.Name), zap.String("tenant", tenant),
+			zap.String("reason", reason), zap.Float64("value", numberValue(dp)),
+			zap.Any("attributes", dp.Attributes().AsRaw()), zap.Any("resource", resource.Attributes().AsRaw()))
+		return
+	}
+
+	if counts.quarantine == nil {
+		counts.quarantine = newOutput(p.identity, p.cfg.Tenancy)
+	}
+	res := make(map[string]string, resource.Attributes().Len())
+	for k, v := range resource.Attributes().All() {
+		res[k] = v.AsString()
+	}
+	record := counts.quarantine.logScope(tenant, res).LogRecords().AppendEmpty()
+	record.SetTimestamp(dp.Timestamp())
+	record.SetObservedTimestamp(pcommon.NewTimestampFromTime(now))
+	record.SetSeverityText("WARN")
+	record.SetSeverityNumber(plog.SeverityNumberWarn)
+	dp.Attributes().CopyTo(record.Attributes())
+	attrs := record.Attributes()
+	attrs.PutStr("quarantine.rule", r.Name)
+	attrs.PutStr("quarantine.reason", reason)
+	attrs.PutDouble("quarantine.value", numberValue(dp))
+	record.Body().SetStr(fmt.Sprintf("%s rejected %g: %s", r.Name, numberValue(dp), reason))
+}
+
+// sendQuarantine sends the quarantined data points of a request to the logs
+// output. It is called after the lock is released.
+func (p *simpleProcessor) sendQuarantine(ctx context.Context, counts *consumeCounts) {
+	if counts.quarantine == nil {
+		return
+	}
+	p.sendLogs(ctx, counts.quarantine.ld, "quarantined data points")
+}
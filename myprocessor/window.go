@@ -0,0 +1,334 @@
+package simpleprocessor
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Window types a rule can use.
+const (
+	// WindowTumbling emits the aggregate of every fixed window once it ends.
+	WindowTumbling = "tumbling"
+	// WindowSliding emits the aggregate of the last window size every step.
+	WindowSliding = "sliding"
+)
+
+// Policies for data points that arrive after their window was flushed.
+const (
+	// LatePolicyDrop drops late data points.
+	LatePolicyDrop = "drop"
+	// LatePolicyCurrent adds late data points to the window that is
+	// currently open.
+	LatePolicyCurrent = "current"
+	// LatePolicyCorrection adds late data points to their own window and
+	// flushes it again, as long as it is within the allowed lateness.
+	LatePolicyCorrection = "correction"
+)
+
+// Sources of the watermark.
+const (
+	// WatermarkProcessingTime follows the collector's clock.
+	WatermarkProcessingTime = "processing_time"
+	// WatermarkEventTime follows the latest data point timestamp seen.
+	WatermarkEventTime = "event_time"
+)
+
+// WindowConfig configures windowed aggregation. Data points are assigned to
+// windows by their own timestamp, windows are aligned to the Unix epoch.
+type WindowConfig struct {
+	// Type is tumbling or sliding. Empty aggregates over the lifetime of
+	// every series.
+	Type string `mapstructure:"type"`
+
+	// Size is the length of a window, e.g. 5m.
+	Size time.Duration `mapstructure:"size"`
+
+	// Step is how often a sliding window is emitted. It must divide Size.
+	Step time.Duration `mapstructure:"step"`
+
+	// Watermark decides when a window is complete and flushed.
+	Watermark WatermarkConfig `mapstructure:"watermark"`
+
+	// LatePolicy is drop (default), current or correction.
+	LatePolicy string `mapstructure:"late_policy"`
+
+	// AllowedLateness is how long after its window was flushed a data point
+	// is still corrected into it. Older data points are dropped.
+	AllowedLateness time.Duration `mapstructure:"allowed_lateness"`
+}
+
+// WatermarkConfig configures the watermark: the event time up to which a
+// rule considers its data complete. Windows that end before it are flushed.
+type WatermarkConfig struct {
+	// Source is processing_time (default) or event_time.
+	Source string `mapstructure:"source"`
+
+	// Delay holds the watermark back to wait for out of order data points.
+	Delay time.Duration `mapstructure:"delay"`
+}
+
+// Validate checks the configuration.
+func (c *WindowConfig) Validate() error {
+	switch c.Type {
+	case "":
+		return nil
+	case WindowTumbling:
+		if c.Step != 0 && c.Step != c.Size {
+			return errors.New("step must not be set for tumbling windows")
+		}
+	case WindowSliding:
+		if c.Step <= 0 {
+			return errors.New("step must be positive for sliding windows")
+		}
+		if c.Size%c.Step != 0 {
+			return errors.New("step must divide size")
+		}
+	default:
+		return errors.New("type must be tumbling or sliding")
+	}
+	if c.Size <= 0 {
+		return errors.New("size must be positive")
+	}
+	switch c.Watermark.Source {
+	case "", WatermarkProcessingTime, WatermarkEventTime:
+	default:
+		return errors.New("watermark: source must be processing_time or event_time")
+	}
+	if c.Watermark.Delay < 0 {
+		return errors.New("watermark: delay must not be negative")
+	}
+	switch c.LatePolicy {
+	case "", LatePolicyDrop, LatePolicyCurrent, LatePolicyCorrection:
+	default:
+		return errors.New("late_policy must be drop, current or correction")
+	}
+	if c.AllowedLateness < 0 {
+		return errors.New("allowed_lateness must not be negative")
+	}
+	if c.AllowedLateness > 0 && c.LatePolicy != LatePolicyCorrection {
+		return errors.New("allowed_lateness only applies to the correction late_policy")
+	}
+	return nil
+}
+
+func (c *WindowConfig) enabled() bool {
+	return c.Type != ""
+}
+
+// step returns the width of a pane. Windows are made of Size/step panes.
+func (c *WindowConfig) step() time.Duration {
+	if c.Type == WindowSliding {
+		return c.Step
+	}
+	return c.Size
+}
+
+// watermark returns the event time up to which the rule's windows are
+// complete, or the zero time if there is none yet.
+func (c *WindowConfig) watermark(state *ruleState, now time.Time) time.Time {
+	if c.Watermark.Source == WatermarkEventTime {
+		if state.MaxEventTime.IsZero() {
+			return time.Time{}
+		}
+		return state.MaxEventTime.Add(-c.Watermark.Delay)
+	}
+	return now.Add(-c.Watermark.Delay)
+}
+
+// pane aggregates the data points of one step of a window.
+type pane struct {
+	aggregate
+
+	// Late holds the data points corrected into the pane since its windows
+	// were last flushed.
+	Late *aggregate `json:"late,omitempty"`
+}
+
+// paneStart returns the start of the pane ts falls into.
+func paneStart(ts time.Time, step time.Duration) int64 {
+	n, width := ts.UnixNano(), step.Nanoseconds()
+	start := n - n%width
+	if n < 0 && n%width != 0 {
+		start -= width
+	}
+	return start
+}
+
+// window is a closed window ready to be flushed.
+type window struct {
+	start, end time.Time
+	agg        *aggregate
+}
+
+// paneFor returns the pane of s a data point with event time ts goes into,
+// creating it if needed. handling is set when the pane's windows were already
+// flushed; a nil pane means the data point must be dropped.
+func (r *rule) paneFor(state *ruleState, s *series, ts, now time.Time) (pn *pane, handling string) {
+	step := r.Window.step()
+	start := paneStart(ts, step)
+	if !s.Emitted.IsZero() && start+step.Nanoseconds() <= s.Emitted.UnixNano() {
+		switch r.Window.LatePolicy {
+		case LatePolicyCurrent:
+			handling = lateCurrent
+			start = paneStart(r.Window.watermark(state, now), step)
+		case LatePolicyCorrection:
+			wm := r.Window.watermark(state, now)
+			if start+r.Window.Size.Nanoseconds()+r.Window.AllowedLateness.Nanoseconds() <= wm.UnixNano() {
+				return nil, lateDropped
+			}
+			handling = lateCorrected
+		default:
+			return nil, lateDropped
+		}
+	}
+
+	pn, ok := s.Panes[start]
+	if !ok {
+		pn = &pane{}
+		if s.Panes == nil {
+			s.Panes = make(map[int64]*pane)
+		}
+		s.Panes[start] = pn
+	}
+	if handling == lateCorrected && pn.Late == nil {
+		pn.Late = &aggregate{}
+	}
+	return pn, handling
+}
+
+// closeWindows returns the windows of s to flush: windows that were corrected
+// since the last flush, then the windows that ended by the watermark and
+// haven't been flushed yet. It drops the panes no later window or correction
+// needs. Windows without data are skipped.
+func (r *rule) closeWindows(s *series, watermark time.Time) ([]window, error) {
+	if len(s.Panes) == 0 || watermark.IsZero() {
+		return nil, nil
+	}
+	step, size := r.Window.step().Nanoseconds(), r.Window.Size.Nanoseconds()
+	starts := make([]int64, 0, len(s.Panes))
+	for start := range s.Panes {
+		starts = append(starts, start)
+	}
+	slices.Sort(starts)
+
+	var windows []window
+	var errs []error
+	// windowAt returns the window ending at end, or false if it has no data.
+	windowAt := func(end int64) (window, bool) {
+		// The panes in [end-size, end) make up the window.
+		lo, _ := slices.BinarySearch(starts, end-size)
+		hi, _ := slices.BinarySearch(starts, end)
+		if lo == hi {
+			return window{}, false
+		}
+		agg := &s.Panes[starts[lo]].aggregate
+		if hi-lo > 1 {
+			agg = &aggregate{}
+			for _, start := range starts[lo:hi] {
+				if err := r.combine(agg, &s.Panes[start].aggregate); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		return window{start: time.Unix(0, end-size), end: time.Unix(0, end), agg: agg}, true
+	}
+
+	// Corrected windows go first so they're flushed before anything newer.
+	// Delta windows only carry the corrections, everything else is flushed
+	// again as a whole.
+	corrected := map[int64]struct{}{}
+	for _, start := range starts {
+		pn := s.Panes[start]
+		if pn.Late == nil {
+			continue
+		}
+		for end := start + step; end <= start+size && end <= s.Emitted.UnixNano(); end += step {
+			corrected[end] = struct{}{}
+		}
+		if r.delta() {
+			windows = append(windows, window{start: time.Unix(0, start), end: time.Unix(0, start+size), agg: pn.Late})
+		}
+		pn.Late = nil
+	}
+	if !r.delta() {
+		for _, end := range slices.Sorted(maps.Keys(corrected)) {
+			if w, ok := windowAt(end); ok {
+				windows = append(windows, w)
+			}
+		}
+	}
+
+	last := paneStart(watermark, r.Window.step())
+	if limit := starts[len(starts)-1] + size; last > limit {
+		last = limit
+	}
+	end := starts[0] + step
+	if !s.Emitted.IsZero() && s.Emitted.UnixNano()+step > end {
+		end = s.Emitted.UnixNano() + step
+	}
+	for end <= last {
+		w, ok := windowAt(end)
+		if !ok {
+			i, _ := slices.BinarySearch(starts, end)
+			if i == len(starts) {
+				break
+			}
+			// Nothing until the next pane, skip ahead to the first window
+			// that contains it.
+			end = starts[i] + step
+			continue
+		}
+		windows = append(windows, w)
+		end += step
+	}
+	if last > s.Emitted.UnixNano() {
+		s.Emitted = time.Unix(0, last)
+	}
+
+	keep := watermark.UnixNano() - size
+	if r.Window.LatePolicy == LatePolicyCorrection {
+		keep -= r.Window.AllowedLateness.Nanoseconds()
+	}
+	for _, start := range starts {
+		if start < keep && start+size <= s.Emitted.UnixNano() {
+			delete(s.Panes, start)
+		}
+	}
+	return windows, errors.Join(errs...)
+}
+
+// combine adds the panes of a sliding window up into dst.
+func (r *rule) combine(dst, src *aggregate) error {
+	dst.Value += src.Value
+	dst.addFraction(src.Remainder)
+	if src.Distinct != nil {
+		if dst.Distinct == nil {
+			dst.Distinct = newHLLSketch(r.DistinctCount.Precision)
+		}
+		if err := dst.Distinct.merge(src.Distinct); err != nil {
+			return err
+		}
+	}
+	if src.Quantile != nil {
+		if dst.Quantile == nil {
+			dst.Quantile = newDDSketch(r.Quantile.scale())
+		}
+		if err := dst.Quantile.merge(src.Quantile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventTime returns the time a data point is windowed by, its timestamp or
+// now if it has none.
+func eventTime(dp pmetric.NumberDataPoint, now time.Time) time.Time {
+	if dp.Timestamp() == 0 {
+		return now
+	}
+	return dp.Timestamp().AsTime()
+}
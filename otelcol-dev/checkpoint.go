@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	simpleprocessor "github.com/myuser/simpleprocessor"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+	"go.opentelemetry.io/collector/otelcol"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+// Sources a checkpoint can be read from besides a plain file path.
+const (
+	sourceFile    = "file"
+	sourceStorage = "storage"
+)
+
+// newCheckpointCommand returns the checkpoint subcommand. It inspects and
+// repairs simple processor checkpoints without starting the collector.
+//
+// Every subcommand takes sources: "file" is the processor's checkpoint_file,
+// "storage" its storage extension, anything else a path to a checkpoint
+// file. Without a source the storage extension is used if one is configured,
+// otherwise the checkpoint file.
+func newCheckpointCommand(set otelcol.CollectorSettings) *cobra.Command {
+	opts := &checkpointOptions{set: set}
+	cmd := &cobra.Command{
+		Use:   "checkpoint",
+		Short: "Inspect and repair simple processor checkpoints offline",
+	}
+	cmd.PersistentFlags().StringVar(&opts.configURI, "config", "", "Collector config URI, e.g. config.yaml or jsonnet://config.jsonnet")
+	cmd.PersistentFlags().StringVar(&opts.processorID, "processor", "simple", "ID of the simple processor or connector in the config")
+	cmd.PersistentFlags().StringVar(&opts.tenant, "tenant", "", "Tenant whose checkpoint to use when tenancy is on, instead of the main checkpoint")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "dump [source]",
+		Short: "Print a checkpoint as JSON in the current format",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.withSource(cmd.Context(), args, func(src checkpointSource) error {
+				data, err := readCheckpoint(cmd.Context(), src)
+				if err != nil {
+					return err
+				}
+				out, err := simpleprocessor.DumpCheckpoint(data)
+				if err != nil {
+					return fmt.Errorf("failed to decode checkpoint from %s: %w", src, err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(out))
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate [source]",
+		Short: "Check that a checkpoint can be loaded and is consistent",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.withSource(cmd.Context(), args, func(src checkpointSource) error {
+				data, err := readCheckpoint(cmd.Context(), src)
+				if err != nil {
+					return err
+				}
+				version, err := simpleprocessor.CheckpointVersion(data)
+				if err != nil {
+					return fmt.Errorf("checkpoint from %s is not valid JSON: %w", src, err)
+				}
+				problems, err := simpleprocessor.ValidateCheckpoint(data)
+				if err != nil {
+					return fmt.Errorf("checkpoint from %s can't be loaded: %w", src, err)
+				}
+				for _, problem := range problems {
+					fmt.Fprintln(cmd.OutOrStdout(), problem)
+				}
+				if len(problems) > 0 {
+					return fmt.Errorf("checkpoint from %s has %d problems", src, len(problems))
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "checkpoint from %s is valid (version %d)\n", src, version)
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "diff <old source> <new source>",
+		Short: "Compare the series values of two checkpoints",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var oldData []byte
+			err := opts.withSource(cmd.Context(), args[:1], func(src checkpointSource) error {
+				var err error
+				oldData, err = readCheckpoint(cmd.Context(), src)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return opts.withSource(cmd.Context(), args[1:], func(src checkpointSource) error {
+				newData, err := readCheckpoint(cmd.Context(), src)
+				if err != nil {
+					return err
+				}
+				diffs, err := simpleprocessor.DiffCheckpoints(oldData, newData)
+				if err != nil {
+					return err
+				}
+				for _, d := range diffs {
+					fmt.Fprintln(cmd.OutOrStdout(), d)
+				}
+				return nil
+			})
+		},
+	})
+
+	var dryRun bool
+	var backup string
+	migrate := &cobra.Command{
+		Use:   "migrate [source]",
+		Short: "Rewrite a checkpoint in the current format",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.withSource(cmd.Context(), args, func(src checkpointSource) error {
+				data, err := readCheckpoint(cmd.Context(), src)
+				if err != nil {
+					return err
+				}
+				migrated, err := simpleprocessor.MigrateCheckpoint(data)
+				if err != nil {
+					return fmt.Errorf("failed to migrate checkpoint from %s: %w", src, err)
+				}
+				if dryRun {
+					fmt.Fprintln(cmd.OutOrStdout(), string(migrated))
+					return nil
+				}
+				return writeCheckpoint(cmd.Context(), src, data, migrated, backup)
+			})
+		},
+	}
+	migrate.Flags().BoolVar(&dryRun, "dry-run", false, "Print the migrated checkpoint instead of writing it")
+	migrate.Flags().StringVar(&backup, "backup", "", "File to save the previous checkpoint to before writing")
+	cmd.AddCommand(migrate)
+
+	var input string
+	rewrite := &cobra.Command{
+		Use:   "rewrite [source]",
+		Short: "Replace a checkpoint with an edited one after validating it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if input == "" {
+				return errors.New("--input is required")
+			}
+			edited, err := os.ReadFile(input)
+			if err != nil {
+				return err
+			}
+			problems, err := simpleprocessor.ValidateCheckpoint(edited)
+			if err != nil {
+				return fmt.Errorf("%s can't be loaded: %w", input, err)
+			}
+			if len(problems) > 0 {
+				for _, problem := range problems {
+					fmt.Fprintln(cmd.ErrOrStderr(), problem)
+				}
+				return fmt.Errorf("%s has %d problems", input, len(problems))
+			}
+			migrated, err := simpleprocessor.MigrateCheckpoint(edited)
+			if err != nil {
+				return err
+			}
+			return opts.withSource(cmd.Context(), args, func(src checkpointSource) error {
+				previous, err := src.read(cmd.Context())
+				if err != nil {
+					return fmt.Errorf("failed to read checkpoint from %s: %w", src, err)
+				}
+				return writeCheckpoint(cmd.Context(), src, previous, migrated, backup)
+			})
+		},
+	}
+	rewrite.Flags().StringVar(&input, "input", "", "Checkpoint file to write, e.g. an edited dump")
+	rewrite.Flags().StringVar(&backup, "backup", "", "File to save the previous checkpoint to before writing")
+	cmd.AddCommand(rewrite)
+
+	return cmd
+}
+
+type checkpointOptions struct {
+	set         otelcol.CollectorSettings
+	configURI   string
+	processorID string
+	tenant      string
+	kind        component.Kind // whether processorID names a processor or a connector, set by loadConfig
+}
+
+// checkpointSource is somewhere a checkpoint can be read from and written to.
+type checkpointSource interface {
+	// read returns (nil, nil) when there is no checkpoint.
+	read(ctx context.Context) ([]byte, error)
+	write(ctx context.Context, data []byte) error
+	close(ctx context.Context) error
+	String() string
+}
+
+func readCheckpoint(ctx context.Context, src checkpointSource) ([]byte, error) {
+	data, err := src.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint from %s: %w", src, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no checkpoint in %s", src)
+	}
+	return data, nil
+}
+
+// writeCheckpoint saves previous to backup, if set, and then writes data.
+func writeCheckpoint(ctx context.Context, src checkpointSource, previous, data []byte, backup string) error {
+	if backup != "" && previous != nil {
+		if err := os.WriteFile(backup, previous, 0644); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+	}
+	if err := src.write(ctx, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint to %s: %w", src, err)
+	}
+	return nil
+}
+
+// withSource opens the source named by args, runs fn and closes it again.
+func (o *checkpointOptions) withSource(ctx context.Context, args []string, fn func(checkpointSource) error) error {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	src, err := o.openSource(ctx, name)
+	if err != nil {
+		return err
+	}
+	return errors.Join(fn(src), src.close(ctx))
+}
+
+func (o *checkpointOptions) openSource(ctx context.Context, name string) (checkpointSource, error) {
+	if name != "" && name != sourceFile && name != sourceStorage {
+		return &fileSource{path: name}, nil
+	}
+
+	cfg, procCfg, procID, err := o.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = sourceFile
+		if procCfg.StorageID != nil {
+			name = sourceStorage
+		}
+	}
+
+	switch name {
+	case sourceFile:
+		if procCfg.CheckpointFile == "" {
+			return nil, fmt.Errorf("processor %q has no checkpoint_file", procID)
+		}
+		if o.tenant != "" {
+			return &fileSource{path: procCfg.CheckpointFile + "." + simpleprocessor.TenantCheckpointKey(o.tenant)}, nil
+		}
+		return &fileSource{path: procCfg.CheckpointFile}, nil
+	default:
+		if procCfg.StorageID == nil {
+			return nil, fmt.Errorf("processor %q has no storage", procID)
+		}
+		return o.openStorage(ctx, cfg, *procCfg.StorageID, procID)
+	}
+}
+
+// loadConfig resolves the collector config with the same providers the
+// collector uses and returns the simple processor's config.
+func (o *checkpointOptions) loadConfig(ctx context.Context) (*otelcol.Config, *simpleprocessor.Config, component.ID, error) {
+	var procID component.ID
+	if err := procID.UnmarshalText([]byte(o.processorID)); err != nil {
+		return nil, nil, procID, fmt.Errorf("invalid processor id %q: %w", o.processorID, err)
+	}
+	if o.configURI == "" {
+		return nil, nil, procID, errors.New("--config is required to resolve the file or storage source")
+	}
+
+	settings := o.set.ConfigProviderSettings
+	settings.ResolverSettings.URIs = []string{o.configURI}
+	provider, err := otelcol.NewConfigProvider(settings)
+	if err != nil {
+		return nil, nil, procID, err
+	}
+	defer provider.Shutdown(ctx)
+
+	factories, err := o.set.Factories()
+	if err != nil {
+		return nil, nil, procID, err
+	}
+	cfg, err := provider.Get(ctx, factories)
+	if err != nil {
+		return nil, nil, procID, err
+	}
+
+	o.kind = component.KindProcessor
+	procCfg, ok := cfg.Processors[procID]
+	if !ok {
+		// The simple connector keeps its checkpoint the same way.
+		o.kind = component.KindConnector
+		if procCfg, ok = cfg.Connectors[procID]; !ok {
+			return nil, nil, procID, fmt.Errorf("processor %q not found in config", procID)
+		}
+	}
+	simpleCfg, ok := procCfg.(*simpleprocessor.Config)
+	if !ok {
+		return nil, nil, procID, fmt.Errorf("processor %q is not a simple processor", procID)
+	}
+	return cfg, simpleCfg, procID, nil
+}
+
+// openStorage creates and starts the storage extension on its own, so the
+// checkpoint can be read exactly as the processor would.
+func (o *checkpointOptions) openStorage(ctx context.Context, cfg *otelcol.Config, storageID, procID component.ID) (checkpointSource, error) {
+	factories, err := o.set.Factories()
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := factories.Extensions[storageID.Type()]
+	if !ok {
+		return nil, fmt.Errorf("extension type %q is not built into this collector", storageID.Type())
+	}
+	extCfg, ok := cfg.Extensions[storageID]
+	if !ok {
+		return nil, fmt.Errorf("extension %q not found in config", storageID)
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		return nil, err
+	}
+	set := extension.Settings{
+		ID: storageID,
+		TelemetrySettings: component.TelemetrySettings{
+			Logger:         logger,
+			TracerProvider: tracenoop.NewTracerProvider(),
+			MeterProvider:  metricnoop.NewMeterProvider(),
+			Resource:       pcommon.NewResource(),
+		},
+		BuildInfo: o.set.BuildInfo,
+	}
+	ext, err := factory.Create(ctx, set, extCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create extension %q: %w", storageID, err)
+	}
+	storageExt, ok := ext.(storage.Extension)
+	if !ok {
+		return nil, fmt.Errorf("extension %q is not a storage extension", storageID)
+	}
+	if err := ext.Start(ctx, nopHost{}); err != nil {
+		return nil, fmt.Errorf("failed to start extension %q: %w", storageID, err)
+	}
+	client, err := storageExt.GetClient(ctx, o.kind, procID, "")
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to get storage client: %w", err), ext.Shutdown(ctx))
+	}
+	key := simpleprocessor.CheckpointKey
+	if o.tenant != "" {
+		key = simpleprocessor.TenantCheckpointKey(o.tenant)
+	}
+	return &storageSource{id: storageID, ext: ext, client: client, key: key}, nil
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) read(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *fileSource) write(_ context.Context, data []byte) error {
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileSource) close(context.Context) error {
+	return nil
+}
+
+func (s *fileSource) String() string {
+	return "file " + s.path
+}
+
+type storageSource struct {
+	id     component.ID
+	ext    extension.Extension
+	client storage.Client
+	key    string
+}
+
+func (s *storageSource) read(ctx context.Context) ([]byte, error) {
+	return s.client.Get(ctx, s.key)
+}
+
+func (s *storageSource) write(ctx context.Context, data []byte) error {
+	return s.client.Set(ctx, s.key, data)
+}
+
+func (s *storageSource) close(ctx context.Context) error {
+	return errors.Join(s.client.Close(ctx), s.ext.Shutdown(ctx))
+}
+
+func (s *storageSource) String() string {
+	return "storage " + s.id.String()
+}
+
+// nopHost is the host the storage extension is started with. Storage
+// extensions don't depend on other extensions.
+type nopHost struct{}
+
+func (nopHost) GetExtensions() map[component.ID]component.Component {
+	return nil
+}
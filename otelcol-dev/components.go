@@ -17,8 +17,10 @@ import (
 	prometheusexporter "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/prometheusexporter"
 	redisstorageextension "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/redisstorageextension"
 	mymiddleware "github.com/wylswz/mymiddleware"
+	boltstorage "github.com/wylswz/boltstorage"
 	batchprocessor "go.opentelemetry.io/collector/processor/batchprocessor"
 	simpleprocessor "github.com/myuser/simpleprocessor"
+	simpleconnector "github.com/myuser/simpleprocessor/simpleconnector"
 	otlpreceiver "go.opentelemetry.io/collector/receiver/otlpreceiver"
 )
 
@@ -31,6 +33,7 @@ func components() (otelcol.Factories, error) {
 	factories.Extensions, err = otelcol.MakeFactoryMap[extension.Factory](
 		redisstorageextension.NewFactory(),
 		mymiddleware.NewFactory(),
+		boltstorage.NewFactory(),
 	)
 	if err != nil {
 		return otelcol.Factories{}, err
@@ -38,6 +41,7 @@ func components() (otelcol.Factories, error) {
 	factories.ExtensionModules = make(map[component.Type]string, len(factories.Extensions))
 	factories.ExtensionModules[redisstorageextension.NewFactory().Type()] = "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/redisstorageextension v0.140.1"
 	factories.ExtensionModules[mymiddleware.NewFactory().Type()] = "github.com/wylswz/mymiddleware v0.0.1"
+	factories.ExtensionModules[boltstorage.NewFactory().Type()] = "github.com/wylswz/boltstorage v0.0.1"
 
 	factories.Receivers, err = otelcol.MakeFactoryMap[receiver.Factory](
 		otlpreceiver.NewFactory(),
@@ -75,11 +79,13 @@ func components() (otelcol.Factories, error) {
 	factories.ProcessorModules[simpleprocessor.NewFactory().Type()] = "github.com/myuser/simpleprocessor v0.0.1"
 
 	factories.Connectors, err = otelcol.MakeFactoryMap[connector.Factory](
+		simpleconnector.NewFactory(),
 	)
 	if err != nil {
 		return otelcol.Factories{}, err
 	}
 	factories.ConnectorModules = make(map[component.Type]string, len(factories.Connectors))
+	factories.ConnectorModules[simpleconnector.NewFactory().Type()] = "github.com/myuser/simpleprocessor v0.0.1"
 
 	return factories, nil
 }